@@ -0,0 +1,210 @@
+package duck
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottlepp/go-duck/duck/data"
+)
+
+const manifestFile = "manifest.json"
+
+// manifest lists the entries of a cache directory
+type manifest struct {
+	Entries []manifestEntry `json:"entries"`
+}
+
+type manifestEntry struct {
+	Name        string    `json:"name"`
+	Query       string    `json:"query"`
+	Fingerprint string    `json:"fingerprint"`
+	Dirs        Dirs      `json:"dirs"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires,omitempty"`
+}
+
+// cacheDir returns the absolute path of the directory, creating it if needed.
+// The path is used by duckdb, which may run in another working directory.
+func cacheDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return abs, os.MkdirAll(abs, 0700)
+}
+
+// save writes the manifest of the entries. It is called with the lock held.
+func (c *lruCache) save() {
+	if c.opts.Dir == "" {
+		return
+	}
+	m := manifest{Entries: []manifestEntry{}}
+	// oldest first, so the order of use is restored by load
+	for el := c.lru.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*cacheEntry)
+		m.Entries = append(m.Entries, manifestEntry{
+			Name:        e.key.Name,
+			Query:       e.key.Query,
+			Fingerprint: e.key.Fingerprint,
+			Dirs:        e.dirs,
+			Created:     e.created,
+			Expires:     e.expires,
+		})
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		logger.Error("failed to encode cache manifest", "error", err)
+		return
+	}
+	// write a new file and rename it, so a crash never leaves a partial manifest
+	file := filepath.Join(c.opts.Dir, manifestFile)
+	if err := os.WriteFile(file+".tmp", b, 0600); err != nil {
+		logger.Error("failed to write cache manifest", "file", file, "error", err)
+		return
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		logger.Error("failed to write cache manifest", "file", file, "error", err)
+	}
+}
+
+// load restores the entries of the manifest that have not expired, and removes
+// the frame directories and temporary manifest that are not used by them
+func (c *lruCache) load() {
+	if err := os.MkdirAll(c.opts.Dir, 0700); err != nil {
+		logger.Error("failed to create cache directory", "dir", c.opts.Dir, "error", err)
+		return
+	}
+
+	var m manifest
+	b, err := os.ReadFile(filepath.Join(c.opts.Dir, manifestFile))
+	if err == nil {
+		err = json.Unmarshal(b, &m)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warn("failed to read cache manifest, removing cached frames", "dir", c.opts.Dir, "error", err)
+	}
+
+	used := map[string]bool{manifestFile: true}
+	now := c.now()
+	for _, me := range m.Entries {
+		if !me.Expires.IsZero() && !now.Before(me.Expires) {
+			continue
+		}
+		if !c.inDir(me.Dirs) || dirsExist(me.Dirs) != nil {
+			continue
+		}
+		e := &cacheEntry{
+			key:     CacheKey{Name: me.Name, Query: me.Query, Fingerprint: me.Fingerprint},
+			dirs:    me.Dirs,
+			bytes:   dirsSize(me.Dirs),
+			created: me.Created,
+			expires: me.Expires,
+		}
+		if old, ok := c.entries[e.key]; ok {
+			c.remove(old)
+		}
+		c.add(e)
+		for _, dir := range me.Dirs {
+			used[filepath.Base(dir)] = true
+		}
+	}
+
+	// the limits may be lower than before the restart
+	for c.lru.Len() > 0 && c.overLimit() {
+		e := c.lru.Back().Value.(*cacheEntry)
+		c.remove(e)
+		for _, dir := range e.dirs {
+			used[filepath.Base(dir)] = false
+		}
+	}
+
+	files, err := os.ReadDir(c.opts.Dir)
+	if err != nil {
+		logger.Error("failed to read cache directory", "dir", c.opts.Dir, "error", err)
+		return
+	}
+	for _, f := range files {
+		// the directory may be shared, so only files written by the cache are removed
+		if used[f.Name()] || !cacheFile(f) {
+			continue
+		}
+		logger.Debug("removing unused cache files", "dir", c.opts.Dir, "name", f.Name())
+		if err := os.RemoveAll(filepath.Join(c.opts.Dir, f.Name())); err != nil {
+			logger.Warn("failed to remove unused cache files", "name", f.Name(), "error", err)
+		}
+	}
+	logger.Debug("loaded cache manifest", "dir", c.opts.Dir, "entries", len(c.entries))
+	c.save()
+}
+
+// cacheFile returns true if the file is a frame directory or a temporary manifest written by the cache
+func cacheFile(f os.DirEntry) bool {
+	if f.IsDir() {
+		return tempDirName.MatchString(f.Name())
+	}
+	return f.Name() == manifestFile+".tmp"
+}
+
+// inDir returns true if the directories are in the cache directory
+func (c *lruCache) inDir(dirs Dirs) bool {
+	for _, dir := range dirs {
+		if filepath.Dir(dir) != filepath.Clean(c.opts.Dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// tempDirName matches the directories created by os.MkdirTemp("", data.TempDirPrefix)
+var tempDirName = regexp.MustCompile(`^` + regexp.QuoteMeta(data.TempDirPrefix) + `\d+$`)
+
+var cleanTempDirsOnce sync.Once
+
+// cleanTempDirs removes frame directories left in the temp dir by processes that did not
+// clean up, such as after a crash. Only directories older than age that contain nothing but
+// frame files are removed, so files of running queries are kept.
+func cleanTempDirs(dir string, age time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Warn("failed to read temp dir", "dir", dir, "error", err)
+		return
+	}
+	cutoff := time.Now().Add(-age)
+	for _, entry := range entries {
+		if !entry.IsDir() || !tempDirName.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if !onlyFrameFiles(path) {
+			continue
+		}
+		logger.Debug("removing orphaned parquet files", "dir", path)
+		if err := os.RemoveAll(path); err != nil {
+			logger.Warn("failed to remove orphaned parquet files", "dir", path, "error", err)
+		}
+	}
+}
+
+// onlyFrameFiles returns true if the directory only contains files written from frames
+func onlyFrameFiles(dir string) bool {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		ext := strings.TrimPrefix(filepath.Ext(f.Name()), ".")
+		if f.IsDir() || data.Extension(ext) != ext {
+			return false
+		}
+	}
+	return true
+}
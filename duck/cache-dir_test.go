@@ -0,0 +1,153 @@
+package duck
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scottlepp/go-duck/duck/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// dirEntry creates a parquet directory in the cache directory
+func dirEntry(t *testing.T, dir string) Dirs {
+	d, err := os.MkdirTemp(dir, data.TempDirPrefix)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(d, "A0.parquet"), make([]byte, 10), 0600)
+	assert.Nil(t, err)
+	return Dirs{"A": d}
+}
+
+func TestCacheDirRestart(t *testing.T) {
+	dir := t.TempDir()
+	key := CacheKey{Name: "foo", Query: "select * from A", Fingerprint: "abc"}
+
+	c := NewCache(CacheOpts{Dir: dir})
+	dirs := dirEntry(t, dir)
+	c.Set(key, dirs)
+	c.Close()
+	assertKept(t, dirs)
+
+	c = NewCache(CacheOpts{Dir: dir})
+	defer c.Close()
+	got, release, ok := c.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, dirs, got)
+	release()
+	assert.Equal(t, int64(10), c.Stats().Bytes)
+}
+
+func TestCacheDirManifest(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCache(CacheOpts{Dir: dir, TTL: 10})
+	defer c.Close()
+	dirs := dirEntry(t, dir)
+	c.Set(CacheKey{Name: "foo", Query: "select * from A"}, dirs)
+
+	b, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	assert.Nil(t, err)
+	var m manifest
+	assert.Nil(t, json.Unmarshal(b, &m))
+	assert.Len(t, m.Entries, 1)
+	assert.Equal(t, "foo", m.Entries[0].Name)
+	assert.Equal(t, dirs, m.Entries[0].Dirs)
+	assert.False(t, m.Entries[0].Expires.IsZero())
+
+	c.Invalidate("foo")
+	b, err = os.ReadFile(filepath.Join(dir, manifestFile))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(b, &m))
+	assert.Empty(t, m.Entries)
+}
+
+func TestCacheDirRemovesExpiredAndUnused(t *testing.T) {
+	dir := t.TempDir()
+	expired, kept := dirEntry(t, dir), dirEntry(t, dir)
+	m := manifest{Entries: []manifestEntry{
+		{Name: "expired", Dirs: expired, Created: time.Now().Add(-time.Hour), Expires: time.Now().Add(-time.Minute)},
+		{Name: "kept", Dirs: kept, Created: time.Now(), Expires: time.Now().Add(time.Hour)},
+	}}
+	b, err := json.Marshal(m)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, manifestFile), b, 0600))
+	// files that are not in the manifest, such as from a crash during a conversion
+	unused := dirEntry(t, dir)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, manifestFile+".tmp"), b, 0600))
+	// files of other programs in the directory
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "important.db"), b, 0600))
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "uploads"), 0700))
+
+	c := NewCache(CacheOpts{Dir: dir, TTL: 3600})
+	defer c.Close()
+	assertRemoved(t, expired)
+	assertRemoved(t, unused)
+	assertKept(t, kept)
+	for _, name := range []string{"important.db", "uploads"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.Nil(t, err, name)
+	}
+	_, err = os.Stat(filepath.Join(dir, manifestFile+".tmp"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, _, ok := c.Get(CacheKey{Name: "expired"})
+	assert.False(t, ok)
+	_, release, ok := c.Get(CacheKey{Name: "kept"})
+	assert.True(t, ok)
+	release()
+}
+
+func TestCacheDirCorruptManifest(t *testing.T) {
+	dir := t.TempDir()
+	cached := dirEntry(t, dir)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, manifestFile), []byte("{"), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "important.db"), []byte("data"), 0600))
+
+	c := NewCache(CacheOpts{Dir: dir})
+	defer c.Close()
+	assertRemoved(t, cached)
+	b, err := os.ReadFile(filepath.Join(dir, "important.db"))
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(b))
+}
+
+func TestCacheDirIgnoresOutsideDirs(t *testing.T) {
+	dir := t.TempDir()
+	outside := cacheDirs(t, 10)
+	b, err := json.Marshal(manifest{Entries: []manifestEntry{{Name: "foo", Dirs: outside}}})
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, manifestFile), b, 0600))
+
+	c := NewCache(CacheOpts{Dir: dir})
+	defer c.Close()
+	_, _, ok := c.Get(CacheKey{Name: "foo"})
+	assert.False(t, ok)
+	assertKept(t, outside)
+}
+
+func TestCleanTempDirs(t *testing.T) {
+	dir := t.TempDir()
+	old := dirEntry(t, dir)
+	recent := dirEntry(t, dir)
+	other, err := os.MkdirTemp(dir, data.TempDirPrefix)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(other, "notes.txt"), nil, 0600))
+	// a directory of another program, with the same kind of files
+	foreign, err := os.MkdirTemp(dir, "duck")
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(foreign, "A0.parquet"), nil, 0600))
+
+	past := time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, os.Chtimes(old["A"], past, past))
+	assert.Nil(t, os.Chtimes(other, past, past))
+	assert.Nil(t, os.Chtimes(foreign, past, past))
+
+	cleanTempDirs(dir, time.Hour)
+	assertRemoved(t, old)
+	assertKept(t, recent)
+	_, err = os.Stat(other)
+	assert.Nil(t, err)
+	_, err = os.Stat(foreign)
+	assert.Nil(t, err)
+}
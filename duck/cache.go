@@ -0,0 +1,299 @@
+package duck
+
+import (
+	"container/list"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CacheKey identifies the parquet files of frames converted for a query
+type CacheKey struct {
+	Name        string
+	Query       string
+	Fingerprint string
+}
+
+// Cache stores the parquet directories of converted frames. The cache owns the
+// directories it is given, and removes them when their entry is removed.
+type Cache interface {
+	// Get returns the directories for the key. They are not removed until release is called.
+	Get(key CacheKey) (dirs Dirs, release func(), ok bool)
+	// Set adds the directories for the key, replacing an existing entry
+	Set(key CacheKey, dirs Dirs)
+	// Remove removes the entry for the key
+	Remove(key CacheKey)
+	// Invalidate removes every entry for the frames name
+	Invalidate(name string)
+	// Purge removes every entry
+	Purge()
+	Stats() CacheStats
+	// Close stops the background work of the cache and removes files that are not kept
+	Close()
+}
+
+// CacheStats are the counters of a cache
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int
+	Bytes     int64
+}
+
+// CacheOpts are the limits of the default cache. Zero means no limit.
+type CacheOpts struct {
+	// TTL is the number of seconds an entry is kept
+	TTL int
+	// MaxEntries is the number of entries kept, the least recently used are evicted first
+	MaxEntries int
+	// MaxBytes is the size of the parquet files kept, the least recently used are evicted first
+	MaxBytes int64
+	// Dir is the directory of the parquet files. When set, a manifest of the entries is kept
+	// in it so they are reused after a restart, and files that are not in the manifest are removed.
+	Dir string
+}
+
+type cacheEntry struct {
+	key     CacheKey
+	dirs    Dirs
+	bytes   int64
+	created time.Time
+	expires time.Time
+	refs    int
+	removed bool
+	element *list.Element
+}
+
+// lruCache is the default Cache. Expired entries are removed by a janitor goroutine that runs
+// while the cache has entries, and the least recently used entries are evicted when a limit is exceeded.
+type lruCache struct {
+	mu      sync.Mutex
+	opts    CacheOpts
+	entries map[CacheKey]*cacheEntry
+	lru     *list.List
+	stats   CacheStats
+	now     func() time.Time
+	done    chan struct{}
+	once    sync.Once
+	// janitor is true while the janitor goroutine runs
+	janitor bool
+}
+
+// NewCache creates the default cache
+func NewCache(opts CacheOpts) Cache {
+	c := &lruCache{
+		opts:    opts,
+		entries: map[CacheKey]*cacheEntry{},
+		lru:     list.New(),
+		now:     time.Now,
+		done:    make(chan struct{}),
+	}
+	if opts.Dir != "" {
+		c.load()
+	}
+	return c
+}
+
+func janitorInterval(ttl int) time.Duration {
+	interval := time.Duration(ttl) * time.Second / 2
+	if interval < time.Second {
+		return time.Second
+	}
+	if interval > time.Minute {
+		return time.Minute
+	}
+	return interval
+}
+
+// expireLoop removes expired entries until the cache is empty or closed
+func (c *lruCache) expireLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.expire()
+			if c.idle() {
+				return
+			}
+		}
+	}
+}
+
+// idle stops the janitor when the cache is empty. The next entry added starts it again.
+func (c *lruCache) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) > 0 {
+		return false
+	}
+	c.janitor = false
+	return true
+}
+
+// expire removes the entries that are past their TTL
+func (c *lruCache) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	expired := false
+	for _, e := range c.entries {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			c.remove(e)
+			c.stats.Evictions++
+			expired = true
+		}
+	}
+	if expired {
+		c.save()
+	}
+}
+
+func (c *lruCache) Get(key CacheKey) (Dirs, func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || (!e.expires.IsZero() && !c.now().Before(e.expires)) {
+		c.stats.Misses++
+		return nil, nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(e.element)
+	e.refs++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			e.refs--
+			if e.removed && e.refs == 0 {
+				go wipe(e.dirs)
+			}
+		})
+	}
+	return e.dirs, release, true
+}
+
+func (c *lruCache) Set(key CacheKey, dirs Dirs) {
+	e := &cacheEntry{key: key, dirs: dirs, bytes: dirsSize(dirs)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.save()
+	e.created = c.now()
+	if c.opts.TTL > 0 {
+		e.expires = e.created.Add(time.Duration(c.opts.TTL) * time.Second)
+	}
+	if old, ok := c.entries[key]; ok {
+		c.remove(old)
+	}
+	c.add(e)
+
+	// evict the least recently used, but always keep the new entry
+	for c.lru.Len() > 1 && c.overLimit() {
+		c.remove(c.lru.Back().Value.(*cacheEntry))
+		c.stats.Evictions++
+	}
+}
+
+func (c *lruCache) add(e *cacheEntry) {
+	e.element = c.lru.PushFront(e)
+	c.entries[e.key] = e
+	c.stats.Bytes += e.bytes
+	if c.opts.TTL > 0 && !c.janitor {
+		c.janitor = true
+		go c.expireLoop(janitorInterval(c.opts.TTL))
+	}
+}
+
+func (c *lruCache) overLimit() bool {
+	return (c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries) ||
+		(c.opts.MaxBytes > 0 && c.stats.Bytes > c.opts.MaxBytes)
+}
+
+func (c *lruCache) Remove(key CacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+		c.save()
+	}
+}
+
+func (c *lruCache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if key.Name == name {
+			c.remove(e)
+		}
+	}
+	c.save()
+}
+
+func (c *lruCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		c.remove(e)
+	}
+	c.save()
+}
+
+func (c *lruCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// Close stops the janitor. The files are removed, unless they are kept in a directory for the next start.
+func (c *lruCache) Close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	if c.opts.Dir == "" {
+		c.Purge()
+	}
+}
+
+// remove deletes the entry. Its files are removed now, or when the last query using them is done.
+func (c *lruCache) remove(e *cacheEntry) {
+	delete(c.entries, e.key)
+	c.lru.Remove(e.element)
+	c.stats.Bytes -= e.bytes
+	e.removed = true
+	if e.refs == 0 {
+		go wipe(e.dirs)
+	}
+}
+
+// dirsSize returns the size of the files in the directories
+func dirsSize(dirs Dirs) int64 {
+	var size int64
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Warn("failed to read size of parquet files", "dir", dir, "error", err)
+		}
+	}
+	return size
+}
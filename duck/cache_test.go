@@ -0,0 +1,212 @@
+package duck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scottlepp/go-duck/duck/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// cacheDirs creates a parquet directory with a file of size bytes
+func cacheDirs(t *testing.T, size int) Dirs {
+	dir, err := os.MkdirTemp(t.TempDir(), data.TempDirPrefix)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(dir, "A0.parquet"), make([]byte, size), 0600)
+	assert.Nil(t, err)
+	return Dirs{"A": dir}
+}
+
+func assertRemoved(t *testing.T, dirs Dirs) {
+	assertRemovedWithin(t, dirs, 2*time.Second)
+}
+
+func assertRemovedWithin(t *testing.T, dirs Dirs, wait time.Duration) {
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(dirs["A"])
+		return os.IsNotExist(err)
+	}, wait, 10*time.Millisecond)
+}
+
+func assertKept(t *testing.T, dirs Dirs) {
+	_, err := os.Stat(dirs["A"])
+	assert.Nil(t, err)
+}
+
+func TestCacheGetSet(t *testing.T) {
+	c := NewCache(CacheOpts{})
+	defer c.Close()
+	key := CacheKey{Name: "foo", Query: "select * from A"}
+
+	_, _, ok := c.Get(key)
+	assert.False(t, ok)
+
+	dirs := cacheDirs(t, 10)
+	c.Set(key, dirs)
+	got, release, ok := c.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, dirs, got)
+	release()
+
+	stats := c.Stats()
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Entries: 1, Bytes: 10}, stats)
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	c := NewCache(CacheOpts{MaxEntries: 2})
+	defer c.Close()
+
+	first, second, third := cacheDirs(t, 1), cacheDirs(t, 1), cacheDirs(t, 1)
+	c.Set(CacheKey{Name: "1"}, first)
+	c.Set(CacheKey{Name: "2"}, second)
+
+	// using the first entry makes the second the least recently used
+	_, release, ok := c.Get(CacheKey{Name: "1"})
+	assert.True(t, ok)
+	release()
+
+	c.Set(CacheKey{Name: "3"}, third)
+	_, _, ok = c.Get(CacheKey{Name: "2"})
+	assert.False(t, ok)
+	assertRemoved(t, second)
+	assertKept(t, first)
+	assertKept(t, third)
+
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.Equal(t, 2, stats.Entries)
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	c := NewCache(CacheOpts{MaxBytes: 100})
+	defer c.Close()
+
+	small, large := cacheDirs(t, 60), cacheDirs(t, 60)
+	c.Set(CacheKey{Name: "small"}, small)
+	c.Set(CacheKey{Name: "large"}, large)
+
+	assertRemoved(t, small)
+	assert.Equal(t, CacheStats{Evictions: 1, Entries: 1, Bytes: 60}, c.Stats())
+
+	// an entry larger than the limit is still kept until the next one is added
+	huge := cacheDirs(t, 200)
+	c.Set(CacheKey{Name: "huge"}, huge)
+	assertKept(t, huge)
+	assertRemoved(t, large)
+}
+
+func TestCacheTTL(t *testing.T) {
+	cache := NewCache(CacheOpts{TTL: 10})
+	defer cache.Close()
+	c := cache.(*lruCache)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	dirs := cacheDirs(t, 1)
+	c.Set(CacheKey{Name: "foo"}, dirs)
+
+	now = now.Add(5 * time.Second)
+	c.expire()
+	_, release, ok := c.Get(CacheKey{Name: "foo"})
+	assert.True(t, ok)
+	release()
+
+	now = now.Add(5 * time.Second)
+	// expired entries are not returned before the janitor removes them
+	_, _, ok = c.Get(CacheKey{Name: "foo"})
+	assert.False(t, ok)
+
+	c.expire()
+	assertRemoved(t, dirs)
+	assert.Equal(t, int64(1), c.Stats().Evictions)
+}
+
+func janitorRunning(c *lruCache) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.janitor
+}
+
+func TestCacheJanitor(t *testing.T) {
+	c := NewCache(CacheOpts{TTL: 1}).(*lruCache)
+	defer c.Close()
+
+	// the janitor only runs while there are entries
+	assert.False(t, janitorRunning(c))
+	dirs := cacheDirs(t, 1)
+	c.Set(CacheKey{Name: "foo"}, dirs)
+	assert.True(t, janitorRunning(c))
+
+	assertRemovedWithin(t, dirs, 5*time.Second)
+	assert.Eventually(t, func() bool { return !janitorRunning(c) }, 3*time.Second, 10*time.Millisecond)
+
+	// a new entry starts it again
+	dirs = cacheDirs(t, 1)
+	c.Set(CacheKey{Name: "foo"}, dirs)
+	assert.True(t, janitorRunning(c))
+	assertRemovedWithin(t, dirs, 5*time.Second)
+}
+
+func TestCacheInUse(t *testing.T) {
+	c := NewCache(CacheOpts{})
+	defer c.Close()
+	dirs := cacheDirs(t, 1)
+	c.Set(CacheKey{Name: "foo"}, dirs)
+
+	_, release, ok := c.Get(CacheKey{Name: "foo"})
+	assert.True(t, ok)
+
+	// the files are kept while the query runs
+	c.Remove(CacheKey{Name: "foo"})
+	time.Sleep(50 * time.Millisecond)
+	assertKept(t, dirs)
+
+	release()
+	assertRemoved(t, dirs)
+
+	// releasing twice is ignored
+	release()
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c := NewCache(CacheOpts{})
+	defer c.Close()
+
+	foo1, foo2, bar := cacheDirs(t, 1), cacheDirs(t, 1), cacheDirs(t, 1)
+	c.Set(CacheKey{Name: "foo", Query: "select 1"}, foo1)
+	c.Set(CacheKey{Name: "foo", Query: "select 2"}, foo2)
+	c.Set(CacheKey{Name: "bar", Query: "select 1"}, bar)
+
+	c.Invalidate("foo")
+	assertRemoved(t, foo1)
+	assertRemoved(t, foo2)
+	assertKept(t, bar)
+	assert.Equal(t, 1, c.Stats().Entries)
+
+	c.Purge()
+	assertRemoved(t, bar)
+	assert.Equal(t, 0, c.Stats().Entries)
+	assert.Equal(t, int64(0), c.Stats().Evictions)
+}
+
+func TestCacheOpts(t *testing.T) {
+	db := NewInMemoryDB()
+	assert.Nil(t, db.cache)
+	assert.Equal(t, CacheStats{}, db.CacheStats())
+
+	db = NewInMemoryDB(Opts{CacheDuration: 10, CacheMaxEntries: 5})
+	assert.Equal(t, CacheOpts{TTL: 10, MaxEntries: 5}, db.cache.(*lruCache).opts)
+	assert.Nil(t, db.Close())
+
+	// a cache from the caller is used and not closed
+	c := NewCache(CacheOpts{})
+	dirs := cacheDirs(t, 1)
+	c.Set(CacheKey{Name: "foo"}, dirs)
+	db = NewInMemoryDB(Opts{Cache: c})
+	assert.Equal(t, c, db.cache)
+	assert.Nil(t, db.Close())
+	assertKept(t, dirs)
+	c.Close()
+}
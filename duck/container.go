@@ -0,0 +1,174 @@
+package duck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// containerRuntime runs the container cli (docker, podman or nerdctl)
+type containerRuntime interface {
+	// run runs the cli with the arguments and waits for it to finish
+	run(ctx context.Context, args ...string) error
+	// exec returns a command that runs in the container and reads from stdin
+	exec(ctx context.Context, name string, args ...string) *exec.Cmd
+	// running returns true if the container is up
+	running(ctx context.Context, name string) bool
+	// remove stops and deletes the container
+	remove(ctx context.Context, name string) error
+}
+
+type cliRuntime struct {
+	bin string
+}
+
+func (r cliRuntime) run(ctx context.Context, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.bin, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w %s", r.bin, args[0], err, stderr.String())
+	}
+	return nil
+}
+
+// execSeq numbers the processes run in containers, so each can be found to kill it
+var execSeq uint64
+
+// execEnv is the environment variable that marks the processes run in containers
+const execEnv = "GO_DUCK_EXEC"
+
+// exec runs the command in the container, marked with an execEnv value of its own. Killing the
+// exec client does not stop the command, and the runtimes can only signal the main process of a
+// container, so the command is found by its mark and killed when ctx is done. That needs sh in the image.
+func (r cliRuntime) exec(ctx context.Context, name string, args ...string) *exec.Cmd {
+	mark := fmt.Sprintf("%s=%d-%d", execEnv, os.Getpid(), atomic.AddUint64(&execSeq, 1))
+	execArgs := append([]string{"exec", "-i", "-e", mark, name}, args...)
+	cmd := exec.CommandContext(ctx, r.bin, execArgs...)
+	cmd.Cancel = func() error {
+		kill, cancel := context.WithTimeout(context.Background(), killTimeout)
+		defer cancel()
+		script := fmt.Sprintf(`for p in /proc/[0-9]*; do tr '\0' '\n' < $p/environ 2>/dev/null | grep -qx '%s' && kill -9 ${p#/proc/}; done; true`, mark)
+		if err := r.run(kill, "exec", name, "sh", "-c", script); err != nil {
+			logger.Warn("failed to kill duckdb in container", "container", name, "error", err)
+		}
+		return cmd.Process.Kill()
+	}
+	return cmd
+}
+
+func (r cliRuntime) running(ctx context.Context, name string) bool {
+	out, err := exec.CommandContext(ctx, r.bin, "inspect", "-f", "{{.State.Running}}", name).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+func (r cliRuntime) remove(ctx context.Context, name string) error {
+	return r.run(ctx, "rm", "-f", name)
+}
+
+const healthInterval = 10 * time.Second
+
+// killTimeout is how long killing a command in a container may take
+const killTimeout = 10 * time.Second
+
+// container is a long running container that duckdb commands are executed in.
+// It is started on first use, checked periodically and after failures, and
+// restarted when it is no longer running.
+type container struct {
+	mu             sync.Mutex
+	runtime        containerRuntime
+	name           string
+	runArgs        []string
+	execArgs       []string
+	started        bool
+	checked        time.Time
+	healthInterval time.Duration
+}
+
+func newContainer(runtime containerRuntime, options []string, image string, exe string, dbPath string) *container {
+	name := fmt.Sprintf("go-duck-%d-%d", os.Getpid(), atomic.AddUint64(&containerSeq, 1))
+
+	// the main process is an in-memory duckdb waiting on stdin, which keeps the container up
+	runArgs := append([]string{"run", "-d", "-i", "--rm", "--name", name}, options...)
+	runArgs = append(runArgs, image)
+
+	if exe == "" {
+		exe = "duckdb"
+	}
+	execArgs := []string{exe}
+	if dbPath != "" {
+		execArgs = append(execArgs, dbPath)
+	}
+
+	return &container{
+		runtime:        runtime,
+		name:           name,
+		runArgs:        runArgs,
+		execArgs:       execArgs,
+		healthInterval: healthInterval,
+	}
+}
+
+// command returns a duckdb process in the container, starting the container if needed
+func (c *container) command(ctx context.Context) (*exec.Cmd, error) {
+	if err := c.ensure(ctx); err != nil {
+		return nil, err
+	}
+	return c.runtime.exec(ctx, c.name, c.execArgs...), nil
+}
+
+func (c *container) ensure(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.started && time.Since(c.checked) < c.healthInterval {
+		return nil
+	}
+	if c.started {
+		c.checked = time.Now()
+		if c.runtime.running(ctx, c.name) {
+			return nil
+		}
+		logger.Warn("container is not running, restarting", "container", c.name)
+		// clean up a stopped container so the name can be reused
+		if err := c.runtime.remove(ctx, c.name); err != nil {
+			logger.Debug("failed to remove container", "container", c.name, "error", err)
+		}
+		c.started = false
+	}
+
+	logger.Debug("starting container", "container", c.name, "args", c.runArgs)
+	if err := c.runtime.run(ctx, c.runArgs...); err != nil {
+		logger.Error("failed to start container", "container", c.name, "error", err)
+		return err
+	}
+	c.started = true
+	c.checked = time.Now()
+	return nil
+}
+
+// failed makes the next command check that the container is still running
+func (c *container) failed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checked = time.Time{}
+}
+
+// stop removes the container
+func (c *container) stop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started {
+		return nil
+	}
+	c.started = false
+	logger.Debug("removing container", "container", c.name)
+	return c.runtime.remove(context.Background(), c.name)
+}
@@ -0,0 +1,137 @@
+package duck
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRuntime records the container calls, and runs cat in place of duckdb so the script is echoed back
+type fakeRuntime struct {
+	mu       sync.Mutex
+	runs     [][]string
+	execs    [][]string
+	removed  []string
+	stopped  bool
+	runError error
+}
+
+func (r *fakeRuntime) run(ctx context.Context, args ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runError != nil {
+		return r.runError
+	}
+	r.runs = append(r.runs, args)
+	r.stopped = false
+	return nil
+}
+
+func (r *fakeRuntime) exec(ctx context.Context, name string, args ...string) *exec.Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.execs = append(r.execs, append([]string{name}, args...))
+	if r.stopped {
+		return exec.CommandContext(ctx, "false")
+	}
+	return exec.CommandContext(ctx, "cat")
+}
+
+func (r *fakeRuntime) running(ctx context.Context, name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.stopped
+}
+
+func (r *fakeRuntime) remove(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removed = append(r.removed, name)
+	return nil
+}
+
+func newFakeContainerDB(name string) (*DuckDB, *fakeRuntime) {
+	db := NewDuckDB(name, Opts{Docker: true, ReuseContainer: true, Image: "duckdb/duckdb:latest"})
+	runtime := &fakeRuntime{}
+	db.container.runtime = runtime
+	return db, runtime
+}
+
+func TestContainerStartedOnce(t *testing.T) {
+	db, runtime := newFakeContainerDB("/data/foo.db")
+
+	for i := 0; i < 3; i++ {
+		res, err := db.Query("SELECT 1;")
+		assert.Nil(t, err)
+		assert.Contains(t, res, "SELECT 1;")
+	}
+
+	assert.Len(t, runtime.runs, 1)
+	assert.Equal(t, []string{"run", "-d", "-i", "--rm", "--name", db.container.name}, runtime.runs[0][:6])
+	assert.Equal(t, "duckdb/duckdb:latest", runtime.runs[0][len(runtime.runs[0])-1])
+
+	assert.Len(t, runtime.execs, 3)
+	assert.Equal(t, []string{db.container.name, "duckdb", "/data/foo.db"}, runtime.execs[0])
+}
+
+func TestContainerRestart(t *testing.T) {
+	db, runtime := newFakeContainerDB("")
+
+	_, err := db.Query("SELECT 1;")
+	assert.Nil(t, err)
+
+	// the container dies, so the next call fails and the one after restarts it
+	runtime.stopped = true
+	_, err = db.Query("SELECT 1;")
+	assert.NotNil(t, err)
+
+	res, err := db.Query("SELECT 2;")
+	assert.Nil(t, err)
+	assert.Contains(t, res, "SELECT 2;")
+
+	assert.Len(t, runtime.runs, 2)
+	assert.Equal(t, []string{db.container.name}, runtime.removed)
+}
+
+func TestContainerHealthCheck(t *testing.T) {
+	db, runtime := newFakeContainerDB("")
+	db.container.healthInterval = 0
+
+	_, err := db.Query("SELECT 1;")
+	assert.Nil(t, err)
+
+	// the stopped container is found before the call is made
+	runtime.stopped = true
+	_, err = db.Query("SELECT 1;")
+	assert.Nil(t, err)
+	assert.Len(t, runtime.runs, 2)
+}
+
+func TestContainerStartError(t *testing.T) {
+	db, runtime := newFakeContainerDB("")
+	runtime.runError = errors.New("no such image")
+
+	_, err := db.Query("SELECT 1;")
+	assert.ErrorContains(t, err, "no such image")
+	assert.Len(t, runtime.execs, 0)
+}
+
+func TestContainerDestroy(t *testing.T) {
+	db, runtime := newFakeContainerDB("")
+
+	// nothing to remove before the container is started
+	err := db.Close()
+	assert.Nil(t, err)
+	assert.Len(t, runtime.removed, 0)
+
+	_, err = db.Query("SELECT 1;")
+	assert.Nil(t, err)
+
+	err = db.Destroy()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{db.container.name}, runtime.removed)
+}
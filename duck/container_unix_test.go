@@ -0,0 +1,73 @@
+//go:build unix
+
+package duck
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerExecKill(t *testing.T) {
+	if _, err := os.Stat("/proc/self/environ"); err != nil {
+		t.Skip("processes are found in /proc")
+	}
+	// a container cli that runs exec commands on the host, and like docker leaves them running
+	// when the client is killed
+	r := cliRuntime{bin: fakeDuckDB(t, `shift
+while [ "${1#-}" != "$1" ]; do
+	case $1 in -e) export "$2"; shift 2;; *) shift;; esac
+done
+shift
+"$@" &
+wait $!
+`)}
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := r.exec(ctx, "duck", "sh", "-c", "echo $$ > "+pidFile+"; exec sleep 30")
+	require.Nil(t, cmd.Start())
+	var pid int
+	require.Eventually(t, func() bool {
+		b, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+		return pid > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NotNil(t, cmd.Wait())
+
+	// the command is killed in the container, not only the exec client
+	assert.Eventually(t, func() bool {
+		out, _ := exec.Command("ps", "-o", "stat=", "-p", strconv.Itoa(pid)).Output()
+		state := strings.TrimSpace(string(out))
+		return state == "" || strings.HasPrefix(state, "Z")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDockerRuntimeError(t *testing.T) {
+	// the runtime could not pull the image
+	runtime := fakeDuckDB(t, printScript("", "Unable to find image 'duckdb:missing' locally\ndocker: Error response from daemon: pull access denied.\n", 125))
+	db := NewInMemoryDB(Opts{Docker: true, Runtime: runtime, Image: "duckdb:missing"})
+	_, err := db.Query("SELECT 1;")
+	var qerr *QueryError
+	require.ErrorAs(t, err, &qerr)
+	assert.Equal(t, KindExecutable, qerr.Kind)
+
+	// errors printed by duckdb are still query errors
+	runtime = fakeDuckDB(t, printScript("", "Error: near line 2: Catalog Error: Table with name t does not exist!\n", 1))
+	db = NewInMemoryDB(Opts{Docker: true, Runtime: runtime})
+	_, err = db.Query("SELECT * FROM t;")
+	require.ErrorAs(t, err, &qerr)
+	assert.Equal(t, KindQuery, qerr.Kind)
+	assert.Equal(t, "Catalog Error", qerr.Class)
+}
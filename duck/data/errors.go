@@ -0,0 +1,30 @@
+package data
+
+import "fmt"
+
+// ConversionError is returned when a frame can not be converted to or from parquet or json results
+type ConversionError struct {
+	Frame string
+	// Column and Type are set when a single column could not be converted
+	Column string
+	Type   string
+	Err    error
+}
+
+func (e *ConversionError) Error() string {
+	msg := "failed to convert frame"
+	if e.Frame != "" {
+		msg += " " + e.Frame
+	}
+	if e.Column != "" {
+		msg += " column " + e.Column
+		if e.Type != "" {
+			msg += fmt.Sprintf(" (%s)", e.Type)
+		}
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,331 @@
+package data
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Formats frames can be written in
+const (
+	FormatParquet = "parquet"
+	// FormatArrow is the Arrow IPC file format, also known as Feather v2
+	FormatArrow  = "arrow"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// TempDirPrefix starts the names of the directories frames are written to, so they are not
+// mistaken for directories of other programs when they are cleaned up
+const TempDirPrefix = "go-duck-"
+
+var extensions = map[string]string{
+	"":            "parquet",
+	FormatParquet: "parquet",
+	FormatArrow:   "arrow",
+	"feather":     "arrow",
+	FormatCSV:     "csv",
+	FormatNDJSON:  "ndjson",
+}
+
+// Extension returns the file extension of the format, or an empty string if it is not supported
+func Extension(format string) string {
+	return extensions[format]
+}
+
+// ToFormat writes the frames to files of the format, in one directory per RefID.
+// The directories are removed if any frame can not be written.
+func ToFormat(frames []*data.Frame, format string, opts ParquetOpts) (map[string]string, error) {
+	var dirs map[string]string
+	var err error
+	switch Extension(format) {
+	case "parquet":
+		dirs, err = toParquet(frames, opts)
+	case "arrow":
+		dirs, err = writeFrames(frames, opts, arrowWriter(opts.Chunk))
+	case "csv":
+		dirs, err = writeFrames(frames, opts, rowWriter("csv", opts.Chunk, writeCSV))
+	case "ndjson":
+		dirs, err = writeFrames(frames, opts, rowWriter("ndjson", opts.Chunk, writeNDJSON))
+	default:
+		return nil, &ConversionError{Err: fmt.Errorf("unsupported format: %s", format)}
+	}
+	if err != nil {
+		for _, dir := range dirs {
+			if rerr := os.RemoveAll(dir); rerr != nil {
+				logger.Error("failed to remove frame files", "dir", dir, "error", rerr)
+			}
+		}
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// frameWriter writes a frame to files in the directory, named after name
+type frameWriter func(frame *data.Frame, dir string, name string) error
+
+// writeFrames merges the frames of each RefID into a directory and writes them with write
+func writeFrames(frames []*data.Frame, opts ParquetOpts, write frameWriter) (map[string]string, error) {
+	dirs := map[string]string{}
+	frameIndex := framesByRef(frames)
+
+	for _, frameList := range frameIndex {
+
+		dir, err := os.MkdirTemp(opts.Dir, TempDirPrefix)
+		if err != nil {
+			logger.Error("failed to create temp dir", "error", err)
+			return dirs, &ConversionError{Err: err}
+		}
+
+		prepareFrames(frameList, opts.LongSeries)
+		for i, frame := range frameList {
+			dirs[frame.RefID] = dir
+			name := fmt.Sprintf("%s%d", frame.RefID, i)
+			if err := write(frame, dir, name); err != nil {
+				return dirs, &ConversionError{Frame: frame.RefID, Err: err}
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// prepareFrames adds the labels of the frames as columns, adds the columns of the other
+// frames as nulls, and renames columns to their display names. The frames must be clones.
+func prepareFrames(frames []*data.Frame, longSeries bool) {
+	labelsToFields(frames, longSeries)
+	mergeFrames(frames)
+	for _, frame := range frames {
+		// Use the display name as the column name. The field is copied so the
+		// caller's frame is unchanged, and can be fingerprinted the same way again.
+		for j, f := range frame.Fields {
+			if f.Config != nil && f.Config.DisplayName != "" {
+				renamed := *f
+				config := *f.Config
+				renamed.Name = config.DisplayName
+				config.DisplayName = ""
+				renamed.Config = &config
+				frame.Fields[j] = &renamed
+			}
+		}
+	}
+}
+
+// WriteNDJSON writes the rows of the frames to w as newline delimited json, with the same
+// columns as the files written by ToFormat. The frames are expected to have the same RefID.
+func WriteNDJSON(w io.Writer, frames []*data.Frame) error {
+	return WriteNDJSONWithOpts(w, frames, ParquetOpts{})
+}
+
+// WriteNDJSONWithOpts is WriteNDJSON with the LongSeries option. The other options are not used.
+func WriteNDJSONWithOpts(w io.Writer, frames []*data.Frame, opts ParquetOpts) error {
+	frameList := make([]*data.Frame, len(frames))
+	for i, f := range frames {
+		frameList[i] = clone(f)
+	}
+	prepareFrames(frameList, opts.LongSeries)
+
+	bw := bufio.NewWriter(w)
+	for _, frame := range frameList {
+		if err := writeNDJSON(bw, frame, 0, frame.Rows()); err != nil {
+			return &ConversionError{Frame: frame.RefID, Err: err}
+		}
+	}
+	return bw.Flush()
+}
+
+// arrowWriter writes frames as Arrow IPC files, split into files of chunk rows when chunk is set
+func arrowWriter(chunk int) frameWriter {
+	return func(frame *data.Frame, dir string, name string) error {
+		table, err := data.FrameToArrowTable(frame)
+		if err != nil {
+			logger.Error("failed to create arrow table", "error", err)
+			return err
+		}
+		defer table.Release()
+
+		if chunk <= 0 || table.NumRows() <= int64(chunk) {
+			return writeArrow(table.Schema(), array.NewTableReader(table, max(table.NumRows(), 1)), path.Join(dir, name+".arrow"))
+		}
+		reader := array.NewTableReader(table, int64(chunk))
+		defer reader.Release()
+		for i := 0; reader.Next(); i++ {
+			rec := reader.Record()
+			single, err := array.NewRecordReader(rec.Schema(), []arrow.Record{rec})
+			if err != nil {
+				return err
+			}
+			err = writeArrow(rec.Schema(), single, path.Join(dir, fmt.Sprintf("%s_%d.arrow", name, i)))
+			if err != nil {
+				return err
+			}
+		}
+		return reader.Err()
+	}
+}
+
+func writeArrow(schema *arrow.Schema, reader array.RecordReader, filename string) error {
+	defer reader.Release()
+	output, err := os.Create(filename)
+	if err != nil {
+		logger.Error("failed to create arrow file", "file", filename, "error", err)
+		return err
+	}
+	defer output.Close()
+
+	writer, err := ipc.NewFileWriter(output, ipc.WithSchema(schema))
+	if err != nil {
+		logger.Error("error creating arrow writer", "error", err)
+		return err
+	}
+	for reader.Next() {
+		if err := writer.Write(reader.Record()); err != nil {
+			logger.Error("error writing arrow", "error", err)
+			return err
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// rowWriter writes the rows of frames with write, split into files of chunk rows when chunk is set
+func rowWriter(ext string, chunk int, write func(w *bufio.Writer, frame *data.Frame, start int, end int) error) frameWriter {
+	return func(frame *data.Frame, dir string, name string) error {
+		rows := frame.Rows()
+		if chunk <= 0 || rows <= chunk {
+			return writeRows(path.Join(dir, name+"."+ext), frame, 0, rows, write)
+		}
+		for i, start := 0, 0; start < rows; i, start = i+1, start+chunk {
+			filename := path.Join(dir, fmt.Sprintf("%s_%d.%s", name, i, ext))
+			if err := writeRows(filename, frame, start, min(start+chunk, rows), write); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func writeRows(filename string, frame *data.Frame, start int, end int, write func(w *bufio.Writer, frame *data.Frame, start int, end int) error) error {
+	output, err := os.Create(filename)
+	if err != nil {
+		logger.Error("failed to create file", "file", filename, "error", err)
+		return err
+	}
+	defer output.Close()
+
+	w := bufio.NewWriter(output)
+	if err := write(w, frame, start, end); err != nil {
+		logger.Error("error writing rows", "file", filename, "error", err)
+		return err
+	}
+	return w.Flush()
+}
+
+// writeCSV writes a header of the field names, and a line for each row. Null values are empty.
+func writeCSV(w *bufio.Writer, frame *data.Frame, start int, end int) error {
+	writer := csv.NewWriter(w)
+	record := make([]string, len(frame.Fields))
+	for i, f := range frame.Fields {
+		record[i] = f.Name
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	for row := start; row < end; row++ {
+		for i, f := range frame.Fields {
+			record[i] = csvValue(f, row)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvValue(f *data.Field, row int) string {
+	v, ok := f.ConcreteAt(row)
+	if !ok {
+		return ""
+	}
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case json.RawMessage:
+		return string(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// writeNDJSON writes a json object for each row. NaN and infinite numbers are written as null.
+func writeNDJSON(w *bufio.Writer, frame *data.Frame, start int, end int) error {
+	names := make([][]byte, len(frame.Fields))
+	for i, f := range frame.Fields {
+		name, err := json.Marshal(f.Name)
+		if err != nil {
+			return err
+		}
+		names[i] = name
+	}
+	for row := start; row < end; row++ {
+		w.WriteByte('{')
+		for i, f := range frame.Fields {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.Write(names[i])
+			w.WriteByte(':')
+			value, err := jsonValue(f, row)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", f.Name, err)
+			}
+			w.Write(value)
+		}
+		w.WriteString("}\n")
+	}
+	return nil
+}
+
+func jsonValue(f *data.Field, row int) ([]byte, error) {
+	v, ok := f.ConcreteAt(row)
+	if !ok {
+		return []byte("null"), nil
+	}
+	switch v := v.(type) {
+	case time.Time:
+		return []byte(strconv.Quote(v.Format(time.RFC3339Nano))), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return []byte("null"), nil
+		}
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return []byte("null"), nil
+		}
+	case json.RawMessage:
+		if len(v) == 0 {
+			return []byte("null"), nil
+		}
+		return v, nil
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,182 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func formatFrames() []*data.Frame {
+	ts := time.Date(2024, 2, 23, 9, 1, 54, 0, time.UTC)
+	value := 1.5
+	frame := data.NewFrame("foo",
+		data.NewField("time", nil, []time.Time{ts, ts.Add(time.Second)}),
+		data.NewField("value", nil, []*float64{&value, nil}),
+		data.NewField("name", nil, []string{"a,b", `say "hi"`}),
+	)
+	frame.RefID = "foo"
+	return []*data.Frame{frame}
+}
+
+func readFile(t *testing.T, file string) string {
+	b, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	return string(b)
+}
+
+func TestToFormatCSV(t *testing.T) {
+	dirs, err := ToFormat(formatFrames(), FormatCSV, ParquetOpts{Dir: t.TempDir()})
+	assert.Nil(t, err)
+
+	expected := "time,value,name\n" +
+		"2024-02-23T09:01:54Z,1.5,\"a,b\"\n" +
+		"2024-02-23T09:01:55Z,,\"say \"\"hi\"\"\"\n"
+	assert.Equal(t, expected, readFile(t, path.Join(dirs["foo"], "foo0.csv")))
+}
+
+func TestToFormatNDJSON(t *testing.T) {
+	dirs, err := ToFormat(formatFrames(), FormatNDJSON, ParquetOpts{Dir: t.TempDir()})
+	assert.Nil(t, err)
+
+	expected := `{"time":"2024-02-23T09:01:54Z","value":1.5,"name":"a,b"}` + "\n" +
+		`{"time":"2024-02-23T09:01:55Z","value":null,"name":"say \"hi\""}` + "\n"
+	assert.Equal(t, expected, readFile(t, path.Join(dirs["foo"], "foo0.ndjson")))
+}
+
+func TestToFormatArrow(t *testing.T) {
+	dirs, err := ToFormat(formatFrames(), "feather", ParquetOpts{Dir: t.TempDir()})
+	assert.Nil(t, err)
+
+	f, err := os.Open(path.Join(dirs["foo"], "foo0.arrow"))
+	assert.Nil(t, err)
+	defer f.Close()
+	reader, err := ipc.NewFileReader(f)
+	assert.Nil(t, err)
+	defer reader.Close()
+
+	assert.Equal(t, 1, reader.NumRecords())
+	record, err := reader.Record(0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), record.NumRows())
+	assert.Equal(t, "time", record.ColumnName(0))
+}
+
+func TestToFormatChunks(t *testing.T) {
+	values := []string{"a", "b", "c", "d", "e"}
+	for _, format := range []string{FormatArrow, FormatCSV, FormatNDJSON} {
+		frame := data.NewFrame("foo", data.NewField("value", nil, values))
+		frame.RefID = "foo"
+
+		dirs, err := ToFormat([]*data.Frame{frame}, format, ParquetOpts{Chunk: 2, Dir: t.TempDir()})
+		assert.Nil(t, err)
+
+		entries, err := os.ReadDir(dirs["foo"])
+		assert.Nil(t, err)
+		assert.Len(t, entries, 3, format)
+		assert.Equal(t, "foo0_0."+Extension(format), entries[0].Name())
+	}
+}
+
+func TestToFormatUnsupported(t *testing.T) {
+	_, err := ToFormat(formatFrames(), "xml", ParquetOpts{})
+	var convErr *ConversionError
+	assert.True(t, errors.As(err, &convErr))
+}
+
+func TestWriteNDJSONMultipleSeries(t *testing.T) {
+	ts := time.Date(2024, 2, 23, 9, 1, 54, 0, time.UTC)
+	frame := data.NewFrame("foo",
+		data.NewField("time", nil, []time.Time{ts}),
+		data.NewField("value", data.Labels{"host": "a"}, []float64{1}),
+		data.NewField("value", data.Labels{"host": "b"}, []float64{2}),
+	)
+	frame.RefID = "foo"
+
+	// with LongSeries each series is a row with its labels as columns
+	var b strings.Builder
+	assert.Nil(t, WriteNDJSONWithOpts(&b, []*data.Frame{frame}, ParquetOpts{LongSeries: true}))
+	expected := `{"time":"2024-02-23T09:01:54Z","value":1,"host":"a"}` + "\n" +
+		`{"time":"2024-02-23T09:01:54Z","value":2,"host":"b"}` + "\n"
+	assert.Equal(t, expected, b.String())
+	assert.Len(t, frame.Fields, 3)
+
+	// otherwise the frame stays wide
+	b.Reset()
+	assert.Nil(t, WriteNDJSON(&b, []*data.Frame{frame}))
+	assert.Equal(t, 1, strings.Count(b.String(), "\n"))
+}
+
+func TestLabelColumnsSorted(t *testing.T) {
+	labels := data.Labels{"zone": "z", "host": "a", "dc": "d"}
+	for i := 0; i < 10; i++ {
+		frame := data.NewFrame("foo", data.NewField("value", labels, []float64{1}))
+		labelsToFields([]*data.Frame{frame}, false)
+		names := []string{}
+		for _, f := range frame.Fields {
+			names = append(names, f.Name)
+		}
+		assert.Equal(t, []string{"value", "dc", "host", "zone"}, names)
+	}
+}
+
+// benchmarkFrames returns time series like those of a Grafana query, with a frame for each series
+func benchmarkFrames(series int, points int) []*data.Frame {
+	start := time.Now()
+	frames := make([]*data.Frame, series)
+	for s := range frames {
+		times := make([]time.Time, points)
+		values := make([]float64, points)
+		for i := range times {
+			times[i] = start.Add(time.Duration(i) * time.Second)
+			values[i] = float64(i) * 1.5
+		}
+		frame := data.NewFrame("foo",
+			data.NewField("time", nil, times),
+			data.NewField("value", data.Labels{"host": fmt.Sprintf("host-%d", s)}, values),
+		)
+		frame.RefID = "A"
+		frames[s] = frame
+	}
+	return frames
+}
+
+func benchmarkToFormat(b *testing.B, format string) {
+	frames := benchmarkFrames(10, 10000)
+	dir := b.TempDir()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dirs, err := ToFormat(frames, format, ParquetOpts{Dir: dir})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		for _, d := range dirs {
+			_ = os.RemoveAll(d)
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkToFormatParquet(b *testing.B) {
+	benchmarkToFormat(b, FormatParquet)
+}
+
+func BenchmarkToFormatArrow(b *testing.B) {
+	benchmarkToFormat(b, FormatArrow)
+}
+
+func BenchmarkToFormatCSV(b *testing.B) {
+	benchmarkToFormat(b, FormatCSV)
+}
+
+func BenchmarkToFormatNDJSON(b *testing.B) {
+	benchmarkToFormat(b, FormatNDJSON)
+}
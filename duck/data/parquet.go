@@ -1,12 +1,19 @@
 package data
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"time"
 
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
 	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/file"
 	"github.com/apache/arrow/go/v15/parquet/pqarrow"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
@@ -14,70 +21,192 @@ import (
 
 var logger = log.DefaultLogger
 
+// ParquetOpts configures how frames are written to files. Chunk and Dir apply to every format,
+// the other options only to parquet.
+type ParquetOpts struct {
+	// Chunk splits frames into files of at most Chunk rows
+	Chunk int
+	// Compression is the codec used for all columns: "snappy" (default), "zstd", "gzip", "brotli" or "none"
+	Compression string
+	// RowGroupSize is the maximum number of rows in a row group. Defaults to 1Mi rows
+	RowGroupSize int64
+	// Dictionary sets which columns are dictionary encoded: "strings" (default), "all" or "none".
+	// Label columns repeat the same value on every row, so strings compress well as dictionaries.
+	Dictionary string
+	// DisableStatistics stops min/max statistics being written for each column
+	DisableStatistics bool
+	// Dir is the directory the parquet directories are created in. Defaults to the temp dir
+	Dir string
+	// LongSeries converts wide time series with a field per series to long, with a row for each
+	// series and time, so the series can be told apart by their label columns
+	LongSeries bool
+}
+
+const defaultRowGroupSize = int64(1024 * 1024)
+
+var codecs = map[string]compress.Compression{
+	"":       compress.Codecs.Snappy,
+	"snappy": compress.Codecs.Snappy,
+	"zstd":   compress.Codecs.Zstd,
+	"gzip":   compress.Codecs.Gzip,
+	"brotli": compress.Codecs.Brotli,
+	"none":   compress.Codecs.Uncompressed,
+}
+
+// ToParquet writes the frames to parquet files, in one directory per RefID.
+// When chunk is greater than zero, frames are split into files of at most chunk rows.
 func ToParquet(frames []*data.Frame, chunk int) (map[string]string, error) {
-	dirs := map[string]string{}
-	frameIndex := framesByRef(frames)
-
-	// TODO - appending lables to fields for now
-	// need to return multiple frames instead
-	// for _, f := range frames {
-	// 	for _, fld := range f.Fields {
-	// 		if len(fld.Labels) > 0 {
-	// 			lbls := fld.Labels.String()
-	// 			fld.Name = fmt.Sprintf("%s %s", fld.Name, lbls)
-	// 		}
-	// 	}
-	// }
-	writerProps := parquet.NewWriterProperties()
-	SIZELEN := int64(1024 * 1024)
-
-	for _, frameList := range frameIndex {
-
-		labelsToFields(frameList)
-
-		dir, err := os.MkdirTemp("", "duck")
+	return ToParquetWithOpts(frames, ParquetOpts{Chunk: chunk})
+}
+
+// ToParquetWithOpts writes the frames to parquet files using the writer options.
+// The directories are removed if any frame can not be written.
+func ToParquetWithOpts(frames []*data.Frame, opts ParquetOpts) (map[string]string, error) {
+	return ToFormat(frames, FormatParquet, opts)
+}
+
+func toParquet(frames []*data.Frame, opts ParquetOpts) (map[string]string, error) {
+	codec, ok := codecs[opts.Compression]
+	if !ok {
+		return nil, &ConversionError{Err: fmt.Errorf("unsupported parquet compression: %s", opts.Compression)}
+	}
+	rowGroupSize := opts.RowGroupSize
+	if rowGroupSize <= 0 {
+		rowGroupSize = defaultRowGroupSize
+	}
+
+	return writeFrames(frames, opts, func(frame *data.Frame, dir string, name string) error {
+		table, err := data.FrameToArrowTable(frame)
 		if err != nil {
-			logger.Error("failed to create temp dir", "error", err)
-			return nil, err
+			logger.Error("failed to create arrow table", "error", err)
+			return err
 		}
+		defer table.Release()
 
-		mergeFrames(frameList)
-		for i, frame := range frameList {
-			dirs[frame.RefID] = dir
+		writerProps := writerProperties(frame, codec, rowGroupSize, opts)
+		return writeParquet(table, dir, name, opts.Chunk, writerProps, rowGroupSize)
+	})
+}
 
-			// Mutate the name to match display name
-			// OK, because framesByRef already clones the field?
-			for _, f := range frame.Fields {
-				if f.Config != nil && f.Config.DisplayName != "" {
-					f.Name = f.Config.DisplayName
-					f.Config.DisplayName = ""
-				}
-			}
+// FromParquet reads a parquet file into a frame.
+// The columns must be types supported by data.FromArrowRecord, with timestamps in nanoseconds.
+func FromParquet(filename string) (*data.Frame, error) {
+	reader, err := file.OpenParquetFile(filename, false)
+	if err != nil {
+		logger.Error("failed to open parquet file", "file", filename, "error", err)
+		return nil, err
+	}
+	defer reader.Close()
 
-			table, err := data.FrameToArrowTable(frame)
-			if err != nil {
-				logger.Error("failed to create arrow table", "error", err)
-				return nil, err
-			}
-			defer table.Release()
+	mem := memory.DefaultAllocator
+	fileReader, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, mem)
+	if err != nil {
+		logger.Error("failed to read parquet file", "file", filename, "error", err)
+		return nil, err
+	}
+	table, err := fileReader.ReadTable(context.Background())
+	if err != nil {
+		logger.Error("failed to read parquet table", "file", filename, "error", err)
+		return nil, err
+	}
+	defer table.Release()
 
-			name := fmt.Sprintf("%s%d", frame.RefID, i)
-			filename := path.Join(dir, name+".parquet")
-			output, err := os.Create(filename)
+	// each row group is a chunk, join them so the frame is built from a single record
+	columns := make([]arrow.Array, table.NumCols())
+	for i := range columns {
+		col := table.Column(i)
+		if len(col.Data().Chunks()) == 0 {
+			columns[i] = array.MakeArrayOfNull(mem, col.DataType(), 0)
+		} else {
+			columns[i], err = array.Concatenate(col.Data().Chunks(), mem)
 			if err != nil {
-				logger.Error("failed to create parquet file", "file", filename, "error", err)
 				return nil, err
 			}
-			defer output.Close()
+		}
+		defer columns[i].Release()
+	}
+	record := array.NewRecord(table.Schema(), columns, table.NumRows())
+	defer record.Release()
 
-			err = pqarrow.WriteTable(table, output, SIZELEN, writerProps, pqarrow.DefaultWriterProps())
-			if err != nil {
-				logger.Error("error writing parquet", "error", err)
-				return nil, err
+	return data.FromArrowRecord(record)
+}
+
+func writerProperties(frame *data.Frame, codec compress.Compression, rowGroupSize int64, opts ParquetOpts) *parquet.WriterProperties {
+	props := []parquet.WriterProperty{
+		parquet.WithCompression(codec),
+		parquet.WithMaxRowGroupLength(rowGroupSize),
+		parquet.WithStats(!opts.DisableStatistics),
+	}
+	switch opts.Dictionary {
+	case "all":
+		props = append(props, parquet.WithDictionaryDefault(true))
+	case "none":
+		props = append(props, parquet.WithDictionaryDefault(false))
+	default:
+		props = append(props, parquet.WithDictionaryDefault(false))
+		for _, f := range frame.Fields {
+			if f.Type() == data.FieldTypeString || f.Type() == data.FieldTypeNullableString {
+				props = append(props, parquet.WithDictionaryPath(parquet.ColumnPath{f.Name}, true))
 			}
 		}
 	}
-	return dirs, nil
+	return parquet.NewWriterProperties(props...)
+}
+
+// writeParquet writes the table to the directory. When chunk is set, the table is split
+// into files of chunk rows so duckdb can scan them in parallel.
+func writeParquet(table arrow.Table, dir string, name string, chunk int, writerProps *parquet.WriterProperties, rowGroupSize int64) error {
+	if chunk <= 0 || table.NumRows() <= int64(chunk) {
+		return writeTable(table, path.Join(dir, name+".parquet"), writerProps, rowGroupSize)
+	}
+
+	reader := array.NewTableReader(table, int64(chunk))
+	defer reader.Release()
+	for i := 0; reader.Next(); i++ {
+		filename := path.Join(dir, fmt.Sprintf("%s_%d.parquet", name, i))
+		err := writeRecord(reader.Record(), filename, writerProps)
+		if err != nil {
+			return err
+		}
+	}
+	return reader.Err()
+}
+
+func writeTable(table arrow.Table, filename string, writerProps *parquet.WriterProperties, rowGroupSize int64) error {
+	output, err := os.Create(filename)
+	if err != nil {
+		logger.Error("failed to create parquet file", "file", filename, "error", err)
+		return err
+	}
+	defer output.Close()
+
+	err = pqarrow.WriteTable(table, output, rowGroupSize, writerProps, pqarrow.DefaultWriterProps())
+	if err != nil {
+		logger.Error("error writing parquet", "error", err)
+		return err
+	}
+	return nil
+}
+
+func writeRecord(record arrow.Record, filename string, writerProps *parquet.WriterProperties) error {
+	output, err := os.Create(filename)
+	if err != nil {
+		logger.Error("failed to create parquet file", "file", filename, "error", err)
+		return err
+	}
+	defer output.Close()
+
+	writer, err := pqarrow.NewFileWriter(record.Schema(), output, writerProps, pqarrow.DefaultWriterProps())
+	if err != nil {
+		logger.Error("error creating parquet writer", "error", err)
+		return err
+	}
+	err = writer.Write(record)
+	if err != nil {
+		logger.Error("error writing parquet", "error", err)
+		return err
+	}
+	return writer.Close()
 }
 
 func framesByRef(frames []*data.Frame) map[string][]*data.Frame {
@@ -94,7 +223,8 @@ func framesByRef(frames []*data.Frame) map[string][]*data.Frame {
 }
 
 func clone(f *data.Frame) *data.Frame {
-	copy := data.NewFrame(f.Name, f.Fields...)
+	// copy the fields slice so fields can be added or replaced without changing f
+	copy := data.NewFrame(f.Name, append([]*data.Field{}, f.Fields...)...)
 	copy.RefID = f.RefID
 	copy.Meta = f.Meta
 	return copy
@@ -155,21 +285,46 @@ func makeArray[T any](length int) []T {
 	return make([]T, length)
 }
 
-func labelsToFields(frames []*data.Frame) {
-	for _, f := range frames {
+// labelsToFields adds a column for each label, in order of name, so labels can be used in queries.
+// With longSeries, wide time series with a field per series are converted to long, with a row per series and time.
+func labelsToFields(frames []*data.Frame, longSeries bool) {
+	for i, f := range frames {
+		if longSeries && multipleSeries(f) {
+			long, err := data.WideToLong(f)
+			if err == nil {
+				long.Name = f.Name
+				long.RefID = f.RefID
+				frames[i] = long
+				continue
+			}
+			logger.Warn("could not convert frame wide to long", "frame", f.RefID, "error", err)
+		}
 		fields := []*data.Field{}
 		for _, fld := range f.Fields {
-			if fld.Labels != nil {
-				for lbl, val := range fld.Labels {
-					newFld := newField(lbl, val, f.Rows())
-					fields = append(fields, newFld)
-				}
+			keys := make([]string, 0, len(fld.Labels))
+			for lbl := range fld.Labels {
+				keys = append(keys, lbl)
+			}
+			sort.Strings(keys)
+			for _, lbl := range keys {
+				fields = append(fields, newField(lbl, fld.Labels[lbl], f.Rows()))
 			}
 		}
 		f.Fields = append(f.Fields, fields...)
 	}
 }
 
+// multipleSeries returns true if the frame is a wide time series with fields of different labels
+func multipleSeries(f *data.Frame) bool {
+	series := map[string]bool{}
+	for _, fld := range f.Fields {
+		if len(fld.Labels) > 0 {
+			series[fld.Labels.String()] = true
+		}
+	}
+	return len(series) > 1 && f.TimeSeriesSchema().Type == data.TimeSeriesTypeWide
+}
+
 func newField(name string, val string, size int) *data.Field {
 	values := make([]string, size)
 	newField := data.NewField(name, nil, values)
@@ -2,11 +2,18 @@ package data
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path"
 	"testing"
+	"time"
 
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/file"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestWrite(t *testing.T) {
@@ -45,3 +52,145 @@ func TestRead(t *testing.T) {
 	fmt.Println(stdout.String())
 	fmt.Println(stderr.String())
 }
+
+func TestFromParquet(t *testing.T) {
+	value := "test"
+	number := 12.5
+	ts := time.Date(2024, 2, 23, 9, 1, 54, 0, time.UTC)
+	frame := data.NewFrame("foo",
+		data.NewField("value", nil, []*string{&value, nil}),
+		data.NewField("number", nil, []*float64{&number, nil}),
+		data.NewField("time", nil, []*time.Time{&ts, nil}),
+	)
+	frame.RefID = "foo"
+	frames := []*data.Frame{frame}
+
+	dirs, err := ToParquet(frames, 0)
+	assert.Nil(t, err)
+	defer os.RemoveAll(dirs["foo"])
+
+	result, err := FromParquet(path.Join(dirs["foo"], "foo0.parquet"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Rows())
+	assert.Equal(t, "value", result.Fields[0].Name)
+
+	v, _ := result.Fields[0].ConcreteAt(0)
+	assert.Equal(t, "test", v)
+	n, _ := result.Fields[1].ConcreteAt(0)
+	assert.Equal(t, 12.5, n)
+	tt, _ := result.Fields[2].ConcreteAt(0)
+	assert.True(t, ts.Equal(tt.(time.Time)))
+	assert.Nil(t, result.Fields[0].At(1))
+}
+
+func TestWriteChunks(t *testing.T) {
+	tests := []struct {
+		chunk int
+		files int
+	}{
+		{chunk: 0, files: 1},
+		{chunk: 3, files: 2},
+		{chunk: 4, files: 2},
+		{chunk: 6, files: 1},
+		{chunk: 1, files: 6},
+	}
+	for _, test := range tests {
+		var values = []string{"test", "test", "test", "test", "test", "test2"}
+		frame := data.NewFrame("foo", data.NewField("value", nil, values))
+		frame.RefID = "foo"
+		frames := []*data.Frame{frame}
+
+		dirs, err := ToParquet(frames, test.chunk)
+		assert.Nil(t, err)
+
+		entries, err := os.ReadDir(dirs["foo"])
+		assert.Nil(t, err)
+		assert.Equal(t, test.files, len(entries), "chunk %d", test.chunk)
+
+		limit := test.chunk
+		if limit == 0 {
+			limit = len(values)
+		}
+		rows := 0
+		for _, entry := range entries {
+			result, err := FromParquet(path.Join(dirs["foo"], entry.Name()))
+			assert.Nil(t, err)
+			assert.LessOrEqual(t, result.Rows(), limit)
+			rows += result.Rows()
+		}
+		assert.Equal(t, len(values), rows)
+
+		err = os.RemoveAll(dirs["foo"])
+		assert.Nil(t, err)
+	}
+}
+
+func TestWriterOpts(t *testing.T) {
+	value := 1.5
+	frame := data.NewFrame("foo",
+		data.NewField("value", nil, []*float64{&value, &value, &value}),
+		data.NewField("host", nil, []string{"a", "a", "a"}),
+	)
+	frame.RefID = "foo"
+
+	tests := []struct {
+		opts        ParquetOpts
+		compression compress.Compression
+		dictionary  []bool
+		stats       bool
+		rowGroups   int
+	}{
+		{opts: ParquetOpts{}, compression: compress.Codecs.Snappy, dictionary: []bool{false, true}, stats: true, rowGroups: 1},
+		{opts: ParquetOpts{Compression: "zstd", Dictionary: "all"}, compression: compress.Codecs.Zstd, dictionary: []bool{true, true}, stats: true, rowGroups: 1},
+		{opts: ParquetOpts{Compression: "none", Dictionary: "none", DisableStatistics: true}, compression: compress.Codecs.Uncompressed, dictionary: []bool{false, false}, stats: false, rowGroups: 1},
+		{opts: ParquetOpts{RowGroupSize: 2}, compression: compress.Codecs.Snappy, dictionary: []bool{false, true}, stats: true, rowGroups: 2},
+	}
+	for _, test := range tests {
+		dirs, err := ToParquetWithOpts([]*data.Frame{frame}, test.opts)
+		assert.Nil(t, err)
+
+		reader, err := file.OpenParquetFile(path.Join(dirs["foo"], "foo0.parquet"), false)
+		assert.Nil(t, err)
+
+		meta := reader.MetaData()
+		assert.Equal(t, test.rowGroups, reader.NumRowGroups())
+		for i, dictionary := range test.dictionary {
+			col, err := meta.RowGroup(0).ColumnChunk(i)
+			assert.Nil(t, err)
+			assert.Equal(t, test.compression, col.Compression())
+			assert.Equal(t, dictionary, col.HasDictionaryPage(), "column %d", i)
+			stats, err := col.StatsSet()
+			assert.Nil(t, err)
+			assert.Equal(t, test.stats, stats)
+		}
+
+		reader.Close()
+		err = os.RemoveAll(dirs["foo"])
+		assert.Nil(t, err)
+	}
+}
+
+func TestWriterOptsInvalidCompression(t *testing.T) {
+	frame := data.NewFrame("foo", data.NewField("value", nil, []string{"test"}))
+	frame.RefID = "foo"
+
+	_, err := ToParquetWithOpts([]*data.Frame{frame}, ParquetOpts{Compression: "lzo"})
+	var convErr *ConversionError
+	assert.True(t, errors.As(err, &convErr))
+}
+
+func TestToParquetKeepsFrame(t *testing.T) {
+	field := data.NewField("value", data.Labels{"host": "a"}, []string{"test"})
+	field.Config = &data.FieldConfig{DisplayName: "display"}
+	frame := data.NewFrame("foo", field)
+	frame.RefID = "foo"
+
+	dirs, err := ToParquet([]*data.Frame{frame}, 0)
+	assert.Nil(t, err)
+	defer os.RemoveAll(dirs["foo"])
+
+	// label columns and display names are only used in the parquet files
+	assert.Len(t, frame.Fields, 1)
+	assert.Equal(t, "value", frame.Fields[0].Name)
+	assert.Equal(t, "display", frame.Fields[0].Config.DisplayName)
+}
@@ -0,0 +1,232 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Column is a result column as reported by DESCRIBE
+type Column struct {
+	Name string `json:"column_name"`
+	Type string `json:"column_type"`
+}
+
+var fieldTypes = map[string]data.FieldType{
+	"BOOLEAN":                  data.FieldTypeNullableBool,
+	"TINYINT":                  data.FieldTypeNullableInt8,
+	"SMALLINT":                 data.FieldTypeNullableInt16,
+	"INTEGER":                  data.FieldTypeNullableInt32,
+	"BIGINT":                   data.FieldTypeNullableInt64,
+	"UTINYINT":                 data.FieldTypeNullableUint8,
+	"USMALLINT":                data.FieldTypeNullableUint16,
+	"UINTEGER":                 data.FieldTypeNullableUint32,
+	"UBIGINT":                  data.FieldTypeNullableUint64,
+	"HUGEINT":                  data.FieldTypeNullableFloat64,
+	"UHUGEINT":                 data.FieldTypeNullableFloat64,
+	"DECIMAL":                  data.FieldTypeNullableFloat64,
+	"FLOAT":                    data.FieldTypeNullableFloat32,
+	"DOUBLE":                   data.FieldTypeNullableFloat64,
+	"DATE":                     data.FieldTypeNullableTime,
+	"TIMESTAMP":                data.FieldTypeNullableTime,
+	"TIMESTAMP_S":              data.FieldTypeNullableTime,
+	"TIMESTAMP_MS":             data.FieldTypeNullableTime,
+	"TIMESTAMP_NS":             data.FieldTypeNullableTime,
+	"TIMESTAMP WITH TIME ZONE": data.FieldTypeNullableTime,
+	"TIME":                     data.FieldTypeNullableString,
+	"TIME WITH TIME ZONE":      data.FieldTypeNullableString,
+	"INTERVAL":                 data.FieldTypeNullableString,
+	"UUID":                     data.FieldTypeNullableString,
+	"BLOB":                     data.FieldTypeNullableString,
+	"BIT":                      data.FieldTypeNullableString,
+	"VARCHAR":                  data.FieldTypeNullableString,
+	"ENUM":                     data.FieldTypeNullableString,
+	"JSON":                     data.FieldTypeNullableJSON,
+}
+
+// FieldType returns the field type used for a duckdb column type.
+// Nested types (LIST, STRUCT, MAP, ARRAY and UNION) are returned as json.
+func FieldType(duckType string) data.FieldType {
+	t := strings.ToUpper(strings.TrimSpace(duckType))
+	if strings.HasSuffix(t, "]") {
+		// LIST (INTEGER[]) or ARRAY (INTEGER[3])
+		return data.FieldTypeNullableJSON
+	}
+	if i := strings.Index(t, "("); i > 0 {
+		// DECIMAL(18,3), STRUCT(a INTEGER), ENUM('a', 'b') ...
+		t = t[:i]
+	}
+	switch t {
+	case "STRUCT", "MAP", "UNION", "LIST":
+		return data.FieldTypeNullableJSON
+	case "TIMESTAMPTZ":
+		t = "TIMESTAMP WITH TIME ZONE"
+	}
+	if ft, ok := fieldTypes[t]; ok {
+		return ft
+	}
+	return data.FieldTypeNullableString
+}
+
+// ToFrame creates a frame from json rows, using the column types to convert the values.
+// The rows should be decoded with json.Decoder.UseNumber so integers keep their precision.
+func ToFrame(name string, columns []Column, rows []map[string]any) (*data.Frame, error) {
+	frame := data.NewFrame(name)
+	for _, col := range columns {
+		fieldType := FieldType(col.Type)
+		field := data.NewFieldFromFieldType(fieldType, len(rows))
+		field.Name = col.Name
+		for i, row := range rows {
+			val, err := convert(fieldType, row[col.Name])
+			if err != nil {
+				return nil, &ConversionError{Frame: name, Column: col.Name, Type: col.Type, Err: err}
+			}
+			field.Set(i, val)
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+	return frame, nil
+}
+
+// convert returns a pointer to the value of the field type, or a nil pointer for nulls
+func convert(fieldType data.FieldType, v any) (any, error) {
+	switch fieldType {
+	case data.FieldTypeNullableBool:
+		return convertValue(v, toBool)
+	case data.FieldTypeNullableInt8:
+		return convertValue(v, toInt[int8](8))
+	case data.FieldTypeNullableInt16:
+		return convertValue(v, toInt[int16](16))
+	case data.FieldTypeNullableInt32:
+		return convertValue(v, toInt[int32](32))
+	case data.FieldTypeNullableInt64:
+		return convertValue(v, toInt[int64](64))
+	case data.FieldTypeNullableUint8:
+		return convertValue(v, toUint[uint8](8))
+	case data.FieldTypeNullableUint16:
+		return convertValue(v, toUint[uint16](16))
+	case data.FieldTypeNullableUint32:
+		return convertValue(v, toUint[uint32](32))
+	case data.FieldTypeNullableUint64:
+		return convertValue(v, toUint[uint64](64))
+	case data.FieldTypeNullableFloat32:
+		return convertValue(v, toFloat[float32](32))
+	case data.FieldTypeNullableFloat64:
+		return convertValue(v, toFloat[float64](64))
+	case data.FieldTypeNullableTime:
+		return convertValue(v, toTime)
+	case data.FieldTypeNullableJSON:
+		return convertValue(v, toJSON)
+	default:
+		return convertValue(v, toString)
+	}
+}
+
+func convertValue[T any](v any, conv func(v any) (T, error)) (*T, error) {
+	if v == nil {
+		return nil, nil
+	}
+	val, err := conv(v)
+	if err != nil {
+		return nil, err
+	}
+	return &val, nil
+}
+
+func toBool(v any) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		return strconv.ParseBool(b)
+	}
+	return false, fmt.Errorf("unexpected boolean value %v", v)
+}
+
+func toInt[T int8 | int16 | int32 | int64](bits int) func(v any) (T, error) {
+	return func(v any) (T, error) {
+		i, err := strconv.ParseInt(numberString(v), 10, bits)
+		return T(i), err
+	}
+}
+
+func toUint[T uint8 | uint16 | uint32 | uint64](bits int) func(v any) (T, error) {
+	return func(v any) (T, error) {
+		i, err := strconv.ParseUint(numberString(v), 10, bits)
+		return T(i), err
+	}
+}
+
+func toFloat[T float32 | float64](bits int) func(v any) (T, error) {
+	return func(v any) (T, error) {
+		f, err := strconv.ParseFloat(numberString(v), bits)
+		return T(f), err
+	}
+}
+
+func numberString(v any) string {
+	switch n := v.(type) {
+	case json.Number:
+		return n.String()
+	case string:
+		return n
+	}
+	return fmt.Sprint(v)
+}
+
+// layouts used by duckdb to print dates and timestamps.
+// Fractional seconds are accepted by time.Parse without being in the layout.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z07",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ParseTime parses a date or timestamp printed by duckdb, such as 2024-02-23 09:01:54.123
+func ParseTime(s string) (time.Time, error) {
+	return toTime(s)
+}
+
+func toTime(v any) (time.Time, error) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unexpected time value %v", v)
+	}
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unexpected time value %s", s)
+}
+
+// toJSON encodes nested values. Lists and objects that duckdb printed as strings of json are used
+// as they are, so they are not encoded as strings again.
+func toJSON(v any) (json.RawMessage, error) {
+	if s, ok := v.(string); ok {
+		s = strings.TrimSpace(s)
+		if (strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{")) && json.Valid([]byte(s)) {
+			return json.RawMessage(s), nil
+		}
+	}
+	return json.Marshal(v)
+}
+
+func toString(v any) (string, error) {
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case json.Number:
+		return s.String(), nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldType(t *testing.T) {
+	types := map[string]data.FieldType{
+		"BOOLEAN":                  data.FieldTypeNullableBool,
+		"INTEGER":                  data.FieldTypeNullableInt32,
+		"BIGINT":                   data.FieldTypeNullableInt64,
+		"UBIGINT":                  data.FieldTypeNullableUint64,
+		"HUGEINT":                  data.FieldTypeNullableFloat64,
+		"DECIMAL(18,3)":            data.FieldTypeNullableFloat64,
+		"DOUBLE":                   data.FieldTypeNullableFloat64,
+		"DATE":                     data.FieldTypeNullableTime,
+		"TIMESTAMP":                data.FieldTypeNullableTime,
+		"TIMESTAMP WITH TIME ZONE": data.FieldTypeNullableTime,
+		"TIMESTAMPTZ":              data.FieldTypeNullableTime,
+		"TIME":                     data.FieldTypeNullableString,
+		"INTERVAL":                 data.FieldTypeNullableString,
+		"UUID":                     data.FieldTypeNullableString,
+		"BLOB":                     data.FieldTypeNullableString,
+		"VARCHAR":                  data.FieldTypeNullableString,
+		"ENUM('a', 'b')":           data.FieldTypeNullableString,
+		"INTEGER[]":                data.FieldTypeNullableJSON,
+		"STRUCT(a INTEGER)":        data.FieldTypeNullableJSON,
+		"MAP(VARCHAR, INTEGER)":    data.FieldTypeNullableJSON,
+		"SOMETHING_NEW":            data.FieldTypeNullableString,
+	}
+	for duckType, expected := range types {
+		assert.Equal(t, expected, FieldType(duckType), duckType)
+	}
+}
+
+func TestToFrame(t *testing.T) {
+	columns := []Column{
+		{Name: "big", Type: "BIGINT"},
+		{Name: "flag", Type: "BOOLEAN"},
+		{Name: "year", Type: "VARCHAR"},
+		{Name: "day", Type: "DATE"},
+		{Name: "ts", Type: "TIMESTAMP"},
+		{Name: "tstz", Type: "TIMESTAMP WITH TIME ZONE"},
+		{Name: "amount", Type: "DECIMAL(18,3)"},
+		{Name: "list", Type: "INTEGER[]"},
+	}
+	res := `[{"big":9223372036854775807,"flag":true,"year":"2024","day":"2024-02-23","ts":"2024-02-23 09:01:54.123","tstz":"2024-02-23 09:01:54+02","amount":1.5,"list":[1,2]},
+{"big":null,"flag":null,"year":null,"day":null,"ts":null,"tstz":null,"amount":null,"list":null}]`
+
+	decoder := json.NewDecoder(strings.NewReader(res))
+	decoder.UseNumber()
+	var rows []map[string]any
+	err := decoder.Decode(&rows)
+	assert.Nil(t, err)
+
+	frame, err := ToFrame("foo", columns, rows)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, frame.Rows())
+
+	big, _ := frame.Fields[0].ConcreteAt(0)
+	assert.Equal(t, int64(9223372036854775807), big)
+
+	flag, _ := frame.Fields[1].ConcreteAt(0)
+	assert.Equal(t, true, flag)
+
+	// strings that look like dates stay strings
+	year, _ := frame.Fields[2].ConcreteAt(0)
+	assert.Equal(t, "2024", year)
+
+	day, _ := frame.Fields[3].ConcreteAt(0)
+	assert.Equal(t, time.Date(2024, 2, 23, 0, 0, 0, 0, time.UTC), day)
+
+	ts, _ := frame.Fields[4].ConcreteAt(0)
+	assert.Equal(t, time.Date(2024, 2, 23, 9, 1, 54, 123000000, time.UTC), ts)
+
+	tstz, _ := frame.Fields[5].ConcreteAt(0)
+	assert.Equal(t, time.Date(2024, 2, 23, 7, 1, 54, 0, time.UTC), tstz)
+
+	amount, _ := frame.Fields[6].ConcreteAt(0)
+	assert.Equal(t, 1.5, amount)
+
+	list, _ := frame.Fields[7].ConcreteAt(0)
+	assert.Equal(t, json.RawMessage(`[1,2]`), list)
+
+	for _, field := range frame.Fields {
+		assert.Nil(t, field.At(1))
+	}
+}
+
+func TestToFrameNested(t *testing.T) {
+	columns := []Column{
+		{Name: "l", Type: "INTEGER[]"},
+		{Name: "s", Type: "STRUCT(a INTEGER)"},
+	}
+	// duckdb -json output of SELECT [1,2] AS l, {'a': 1} AS s, and the same values printed as strings
+	res := `[{"l":[1,2],"s":{"a":1}},
+{"l":"[1, 2]","s":"{\"a\": 1}"},
+{"l":"[1, 2","s":"{'a': 1}"}]`
+
+	decoder := json.NewDecoder(strings.NewReader(res))
+	decoder.UseNumber()
+	var rows []map[string]any
+	assert.Nil(t, decoder.Decode(&rows))
+
+	frame, err := ToFrame("foo", columns, rows)
+	assert.Nil(t, err)
+
+	l, _ := frame.Fields[0].ConcreteAt(0)
+	assert.Equal(t, json.RawMessage(`[1,2]`), l)
+	s, _ := frame.Fields[1].ConcreteAt(0)
+	assert.Equal(t, json.RawMessage(`{"a":1}`), s)
+
+	// strings of json are not encoded again
+	l, _ = frame.Fields[0].ConcreteAt(1)
+	assert.Equal(t, json.RawMessage(`[1, 2]`), l)
+	s, _ = frame.Fields[1].ConcreteAt(1)
+	assert.Equal(t, json.RawMessage(`{"a": 1}`), s)
+
+	// other strings are json strings
+	l, _ = frame.Fields[0].ConcreteAt(2)
+	assert.Equal(t, json.RawMessage(`"[1, 2"`), l)
+	s, _ = frame.Fields[1].ConcreteAt(2)
+	assert.Equal(t, json.RawMessage(`"{'a': 1}"`), s)
+}
+
+func TestToFrameInvalidValue(t *testing.T) {
+	columns := []Column{{Name: "i", Type: "INTEGER"}}
+	rows := []map[string]any{{"i": json.Number("12345678901")}}
+
+	_, err := ToFrame("foo", columns, rows)
+	assert.ErrorContains(t, err, "column i (INTEGER)")
+}
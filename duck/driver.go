@@ -0,0 +1,491 @@
+package duck
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/scottlepp/go-duck/duck/data"
+)
+
+// DriverName is the name the database/sql driver is registered with
+const DriverName = "duckcli"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver is a database/sql driver that runs statements with the duckdb cli, without cgo.
+// The data source name is the path of the database file, or empty (or ":memory:") for an
+// in-memory database. Use NewConnector and sql.OpenDB to open a DuckDB with options.
+type Driver struct{}
+
+// Open returns a connection to the database
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	return &conn{db: openDSN(dsn), own: true}, nil
+}
+
+// OpenConnector returns a connector for the database, which is shared by its connections
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	return &connector{db: openDSN(dsn), own: true}, nil
+}
+
+// openDSN opens the database of the data source name. File based databases run on a
+// session, so they support transactions.
+func openDSN(dsn string) *DuckDB {
+	if dsn == "" || dsn == ":memory:" {
+		return NewInMemoryDB()
+	}
+	return NewDuckDB(dsn, Opts{Sessions: 1})
+}
+
+// NewConnector returns a connector for sql.OpenDB that runs statements with the DuckDB.
+// The DuckDB is not closed when the sql.DB is closed.
+func NewConnector(db *DuckDB) driver.Connector {
+	return &connector{db: db}
+}
+
+type connector struct {
+	db  *DuckDB
+	own bool
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &Driver{}
+}
+
+// Close closes the DuckDB when it was opened for the connector
+func (c *connector) Close() error {
+	if c.own {
+		return c.db.Close()
+	}
+	return nil
+}
+
+// conn runs each statement in its own duckdb process, or on a session of the pool.
+// Each statement of an in-memory database without sessions runs on an empty database.
+type conn struct {
+	db  *DuckDB
+	own bool
+	tx  *tx
+}
+
+var (
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.NamedValueChecker  = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext returns a statement for the query. The query is sent to duckdb when it is run.
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return &stmt{c: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		// the session of the transaction goes back to the pool
+		_ = c.tx.Rollback()
+	}
+	if c.own {
+		return c.db.Close()
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction on a session of the pool, which runs the statements of the
+// transaction and is kept from other calls until it is committed or rolled back. Other calls
+// fail while every session is held by a transaction. The DuckDB needs Opts.Sessions, since
+// a transaction can't span duckdb processes. File data source names have a session.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.db.pool == nil {
+		return nil, validationError("transactions need Opts.Sessions")
+	}
+	if c.tx != nil {
+		return nil, validationError("a transaction is already open")
+	}
+	if sql.IsolationLevel(opts.Isolation) != sql.LevelDefault {
+		return nil, validationError("unsupported isolation level: %s", sql.IsolationLevel(opts.Isolation))
+	}
+	s, err := c.db.pool.hold(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx.Err())
+		}
+		return nil, sessionError(err)
+	}
+	t := &tx{c: c, s: s, readOnly: opts.ReadOnly}
+	if _, err := t.run(ctx, []string{"BEGIN TRANSACTION;"}); err != nil {
+		c.db.pool.unhold(s)
+		return nil, err
+	}
+	c.tx = t
+	return t, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	_, err := c.db.RunCommandsContext(ctx, []string{"SELECT 1;"})
+	return err
+}
+
+// CheckNamedValue accepts any value Literal can encode, such as slices for LIST parameters
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	_, err := Literal(nv.Value)
+	return err
+}
+
+// ExecContext runs the statement, and returns the number of rows changed by INSERT, UPDATE and DELETE
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.tx != nil && c.tx.readOnly {
+		return nil, validationError("statements can not be executed in a read only transaction")
+	}
+	commands, execute, err := bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	change := isChange(query)
+	script := commands
+	if change {
+		script = make([]string, 0, len(commands)+2)
+		script = append(script, commands[:execute]...)
+		script = append(script, ".changes on", commands[execute], ".changes off")
+		script = append(script, commands[execute+1:]...)
+	}
+	out, err := c.run(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+	var changes int64
+	if change {
+		_, changes = splitChanges(out)
+	}
+	return driver.RowsAffected(changes), nil
+}
+
+// QueryContext runs the query. The columns of queries such as SELECT are typed by DESCRIBE,
+// other statements that return rows, such as INSERT ... RETURNING, have untyped columns.
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	commands, _, err := bind(query, args)
+	if err != nil {
+		return nil, err
+	}
+	typed := isQuery(query)
+	if typed {
+		describeCommands, _, err := bind(describe(query), args)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(describeCommands, commands...)
+	}
+	out, err := c.run(ctx, commands)
+	if err != nil {
+		return nil, err
+	}
+	if typed {
+		return typedRows(out)
+	}
+	return untypedRows(out)
+}
+
+// run runs the commands, in the open transaction if there is one, and returns what they printed
+func (c *conn) run(ctx context.Context, commands []string) (string, error) {
+	script := append([]string{".mode json", ".print " + statementMarker}, commands...)
+	var out string
+	var err error
+	if c.tx != nil {
+		out, err = c.tx.run(ctx, script)
+	} else {
+		out, err = c.db.runCommands(ctx, script)
+	}
+	if err != nil {
+		return "", err
+	}
+	_, output, _ := strings.Cut(out, statementMarker+newline)
+	return output, nil
+}
+
+// bind returns the commands that run the query with the args, and the index of the command that
+// executes it: the query itself when there are no args, or its PREPARE, EXECUTE and DEALLOCATE
+func bind(query string, args []driver.NamedValue) ([]string, int, error) {
+	if len(args) == 0 {
+		return []string{terminate(query)}, 0, nil
+	}
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+		if arg.Name != "" {
+			values[i] = Named(arg.Name, arg.Value)
+		}
+	}
+	commands, err := prepare(query, values)
+	return commands, 1, err
+}
+
+// tx is a transaction open on the session s, which is taken from the pool until it ends
+type tx struct {
+	c        *conn
+	s        *session
+	readOnly bool
+}
+
+// run runs the commands on the session of the transaction
+func (t *tx) run(ctx context.Context, commands []string) (string, error) {
+	if !t.s.alive() {
+		// a replaced session lost the transaction
+		return "", newError(KindProcess, "the duckdb session of the transaction exited", nil)
+	}
+	return t.c.db.runCommandsOn(ctx, t.s, commands)
+}
+
+func (t *tx) Commit() error {
+	return t.end("COMMIT;")
+}
+
+func (t *tx) Rollback() error {
+	return t.end("ROLLBACK;")
+}
+
+// end runs the statement that ends the transaction, and returns the session to the pool
+func (t *tx) end(statement string) error {
+	if t.c.tx != t {
+		return sql.ErrTxDone
+	}
+	t.c.tx = nil
+	defer t.c.db.pool.unhold(t.s)
+	_, err := t.run(context.Background(), []string{statement})
+	if err != nil {
+		// the session may still be in the transaction, so it is replaced
+		t.s.kill()
+	}
+	return err
+}
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+var (
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput returns -1, since the parameters are only known to duckdb
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.c.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.c.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+// rows are the results of a query, read when the query is run
+type rows struct {
+	columns []data.Column
+	values  [][]driver.Value
+	row     int
+}
+
+var (
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+	_ driver.RowsColumnTypeScanType         = (*rows)(nil)
+)
+
+// typedRows converts the output of DESCRIBE and the query with the column types
+func typedRows(out string) (*rows, error) {
+	columns, results, err := decodeResults(out)
+	if err != nil {
+		return nil, conversionError(err)
+	}
+	frame, err := data.ToFrame("", columns, results)
+	if err != nil {
+		return nil, conversionError(err)
+	}
+	r := &rows{columns: columns, values: make([][]driver.Value, len(results))}
+	for i := range results {
+		r.values[i] = make([]driver.Value, len(columns))
+		for j, field := range frame.Fields {
+			if v, ok := field.ConcreteAt(i); ok {
+				r.values[i][j] = driverValue(v)
+			}
+		}
+	}
+	return r, nil
+}
+
+// untypedRows converts json rows, with the columns in the order of the first row
+func untypedRows(out string) (*rows, error) {
+	results, err := decodeRows(out)
+	if err != nil {
+		return nil, conversionError(err)
+	}
+	r := &rows{values: make([][]driver.Value, len(results))}
+	if len(results) == 0 {
+		return r, nil
+	}
+	names, err := columnOrder(out)
+	if err != nil {
+		return nil, conversionError(err)
+	}
+	for _, name := range names {
+		r.columns = append(r.columns, data.Column{Name: name})
+	}
+	for i, result := range results {
+		r.values[i] = make([]driver.Value, len(names))
+		for j, name := range names {
+			r.values[i][j] = jsonValue(result[name])
+		}
+	}
+	return r, nil
+}
+
+// columnOrder returns the keys of the first json row in order
+func columnOrder(out string) ([]string, error) {
+	var results []json.RawMessage
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &results); err != nil {
+		return nil, err
+	}
+	return objectKeys(results[0])
+}
+
+// driverValue converts a field value to a value database/sql can scan
+func driverValue(v any) driver.Value {
+	switch v := v.(type) {
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return v
+	case float32:
+		// keep the shortest decimal representation of the float32
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+		return f
+	case json.RawMessage:
+		return []byte(v)
+	}
+	return v
+}
+
+// jsonValue converts a decoded json value to a value database/sql can scan
+func jsonValue(v any) driver.Value {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any, []any:
+		b, _ := json.Marshal(v)
+		return b
+	}
+	return v
+}
+
+func (r *rows) Columns() []string {
+	names := make([]string, len(r.columns))
+	for i, col := range r.columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+func (r *rows) Close() error {
+	r.values = nil
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.row >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.row])
+	r.row++
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns the duckdb type of the column, such as INTEGER or VARCHAR[]
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.columns[index].Type
+}
+
+// ColumnTypeScanType returns the type of the values of the column
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	if r.columns[index].Type == "" {
+		return reflect.TypeOf((*any)(nil)).Elem()
+	}
+	switch data.FieldType(r.columns[index].Type) {
+	case sdk.FieldTypeNullableBool:
+		return reflect.TypeOf(false)
+	case sdk.FieldTypeNullableInt8, sdk.FieldTypeNullableInt16, sdk.FieldTypeNullableInt32, sdk.FieldTypeNullableInt64,
+		sdk.FieldTypeNullableUint8, sdk.FieldTypeNullableUint16, sdk.FieldTypeNullableUint32:
+		return reflect.TypeOf(int64(0))
+	case sdk.FieldTypeNullableUint64:
+		return reflect.TypeOf(uint64(0))
+	case sdk.FieldTypeNullableFloat32, sdk.FieldTypeNullableFloat64:
+		return reflect.TypeOf(float64(0))
+	case sdk.FieldTypeNullableTime:
+		return reflect.TypeOf(time.Time{})
+	case sdk.FieldTypeNullableJSON:
+		return reflect.TypeOf([]byte{})
+	}
+	return reflect.TypeOf("")
+}
@@ -0,0 +1,343 @@
+//go:build unix
+
+package duck
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriverQueryTypes(t *testing.T) {
+	stdout := `[{"column_name":"i","column_type":"BIGINT"},{"column_name":"f","column_type":"FLOAT"},` +
+		`{"column_name":"s","column_type":"VARCHAR"},{"column_name":"t","column_type":"TIMESTAMP"},` +
+		`{"column_name":"l","column_type":"INTEGER[]"},{"column_name":"b","column_type":"BOOLEAN"}]` + "\n" +
+		`[{"i":9007199254740993,"f":0.1,"s":"a","t":"2024-02-23 09:01:54","l":[1,2],"b":true},` + "\n" +
+		`{"i":null,"f":null,"s":null,"t":null,"l":null,"b":null}]` + "\n"
+	exe := fakeDuckDB(t, printScript(statementMarker+"\n"+stdout, "", 0))
+	db := sql.OpenDB(NewConnector(NewInMemoryDB(Opts{Exe: exe})))
+	defer db.Close()
+
+	rows, err := db.Query("SELECT * FROM t WHERE s = $1", "a")
+	assert.Nil(t, err)
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"i", "f", "s", "t", "l", "b"}, columns)
+	types, err := rows.ColumnTypes()
+	assert.Nil(t, err)
+	assert.Equal(t, "BIGINT", types[0].DatabaseTypeName())
+	assert.Equal(t, reflect.TypeOf(int64(0)), types[0].ScanType())
+	assert.Equal(t, reflect.TypeOf(float64(0)), types[1].ScanType())
+	assert.Equal(t, reflect.TypeOf(time.Time{}), types[3].ScanType())
+	assert.Equal(t, reflect.TypeOf([]byte{}), types[4].ScanType())
+
+	var i int64
+	var f float64
+	var s string
+	var ts time.Time
+	var l []byte
+	var b bool
+	assert.True(t, rows.Next())
+	assert.Nil(t, rows.Scan(&i, &f, &s, &ts, &l, &b))
+	assert.Equal(t, int64(9007199254740993), i)
+	assert.Equal(t, 0.1, f)
+	assert.Equal(t, "a", s)
+	assert.Equal(t, time.Date(2024, 2, 23, 9, 1, 54, 0, time.UTC), ts)
+	assert.Equal(t, "[1,2]", string(l))
+	assert.True(t, b)
+
+	var ni sql.NullInt64
+	var nf sql.NullFloat64
+	var ns sql.NullString
+	var nt sql.NullTime
+	var nl []byte
+	var nb sql.NullBool
+	assert.True(t, rows.Next())
+	assert.Nil(t, rows.Scan(&ni, &nf, &ns, &nt, &nl, &nb))
+	assert.False(t, ni.Valid || nf.Valid || ns.Valid || nt.Valid || nb.Valid)
+	assert.Nil(t, nl)
+	assert.False(t, rows.Next())
+	assert.Nil(t, rows.Err())
+
+	script := fakeInput(t, exe)
+	assert.Contains(t, script, "AS DESCRIBE SELECT * FROM t WHERE s = $1\n;")
+	assert.Contains(t, script, "AS SELECT * FROM t WHERE s = $1\n;")
+	assert.Contains(t, script, "('a');")
+}
+
+func TestDriverQueryUntyped(t *testing.T) {
+	exe := fakeDuckDB(t, printScript(statementMarker+"\n"+`[{"z":1,"a":"x","n":{"k":[1]}}]`+"\n", "", 0))
+	db := sql.OpenDB(NewConnector(NewInMemoryDB(Opts{Exe: exe})))
+	defer db.Close()
+
+	rows, err := db.Query("INSERT INTO t VALUES (1, 'x') RETURNING *")
+	assert.Nil(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"z", "a", "n"}, columns)
+
+	var z int
+	var a string
+	var n string
+	assert.True(t, rows.Next())
+	assert.Nil(t, rows.Scan(&z, &a, &n))
+	assert.Equal(t, 1, z)
+	assert.Equal(t, `{"k":[1]}`, n)
+}
+
+func TestDriverExec(t *testing.T) {
+	exe := fakeDuckDB(t, printScript(statementMarker+"\nchanges: 3   total_changes: 3\n", "", 0))
+	db := sql.OpenDB(NewConnector(NewInMemoryDB(Opts{Exe: exe})))
+	defer db.Close()
+
+	res, err := db.Exec("UPDATE t SET name = $name WHERE id = ANY($ids)", sql.Named("name", "it's"), sql.Named("ids", []int{1, 2, 3}))
+	assert.Nil(t, err)
+	affected, err := res.RowsAffected()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), affected)
+	_, err = res.LastInsertId()
+	assert.NotNil(t, err)
+
+	lines := strings.Split(fakeInput(t, exe), "\n")
+	execute := 0
+	for i, line := range lines {
+		if strings.HasPrefix(line, "EXECUTE") {
+			execute = i
+		}
+	}
+	assert.Equal(t, ".changes on ", lines[execute-1])
+	assert.Contains(t, lines[execute], "(name := 'it''s', ids := [1, 2, 3]);")
+	assert.Equal(t, ".changes off ", lines[execute+1])
+
+	res, err = db.Exec("CREATE TABLE t2 (i INTEGER)")
+	assert.Nil(t, err)
+	affected, _ = res.RowsAffected()
+	assert.Equal(t, int64(0), affected)
+	assert.NotContains(t, fakeInput(t, exe), ".changes on")
+}
+
+func TestDriverPrepare(t *testing.T) {
+	exe := fakeDuckDB(t, printScript(statementMarker+"\nchanges: 1   total_changes: 1\n", "", 0))
+	db := sql.OpenDB(NewConnector(NewInMemoryDB(Opts{Exe: exe})))
+	defer db.Close()
+
+	stmt, err := db.Prepare("INSERT INTO t VALUES ($1, $2)")
+	assert.Nil(t, err)
+	defer stmt.Close()
+	for _, v := range []string{"a", "'); DROP TABLE t; --"} {
+		res, err := stmt.Exec(1, v)
+		assert.Nil(t, err)
+		affected, _ := res.RowsAffected()
+		assert.Equal(t, int64(1), affected)
+	}
+	assert.Contains(t, fakeInput(t, exe), "(1, '''); DROP TABLE t; --');")
+
+	_, err = stmt.Exec(make(chan int))
+	assert.NotNil(t, err)
+}
+
+func TestDriverError(t *testing.T) {
+	exe := fakeDuckDB(t, printScript("", "Error: near line 3: Catalog Error: Table with name missing does not exist!\n", 1))
+	db := sql.OpenDB(NewConnector(NewInMemoryDB(Opts{Exe: exe})))
+	defer db.Close()
+
+	_, err := db.Query("SELECT * FROM missing")
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, "Catalog Error", qerr.Class)
+}
+
+func TestDriverTx(t *testing.T) {
+	exe := fakeSessionDuckDB(t)
+	duck := NewDuckDB(filepath.Join(t.TempDir(), "foo.db"), Opts{Exe: exe, Sessions: 1})
+	defer duck.Close()
+	db := sql.OpenDB(NewConnector(duck))
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.Nil(t, err)
+	_, err = tx.Exec("INSERT INTO t VALUES (1)")
+	assert.Nil(t, err)
+	_, err = tx.Exec("INSERT INTO t VALUES ($1)", 2)
+	assert.Nil(t, err)
+
+	// the only session is held by the transaction, so other statements fail instead of waiting
+	_, err = db.Exec("INSERT INTO t VALUES (4)")
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindValidation, qerr.Kind)
+
+	assert.Nil(t, tx.Commit())
+	assert.ErrorIs(t, tx.Commit(), sql.ErrTxDone)
+
+	// each statement runs once, in a transaction held open on the session
+	script := fakeInput(t, exe)
+	assert.Equal(t, 1, strings.Count(script, "INSERT INTO t VALUES (1)"))
+	assert.Equal(t, 1, strings.Count(script, "EXECUTE"))
+	begin := strings.Index(script, "BEGIN TRANSACTION;")
+	first := strings.Index(script, "INSERT INTO t VALUES (1)")
+	commit := strings.Index(script, "COMMIT;")
+	assert.True(t, begin >= 0 && begin < first && first < commit)
+	assert.NotContains(t, script, "ROLLBACK;")
+
+	tx, err = db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	require.Nil(t, err)
+	_, err = tx.Exec("DELETE FROM t")
+	assert.NotNil(t, err)
+	assert.Nil(t, tx.Rollback())
+	assert.Contains(t, fakeInput(t, exe), "ROLLBACK;")
+
+	_, err = db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	assert.NotNil(t, err)
+
+	// the session is back in the pool
+	_, err = db.Exec("INSERT INTO t VALUES (3)")
+	assert.Nil(t, err)
+}
+
+func TestDriverTxWithoutSessions(t *testing.T) {
+	db, err := sql.Open(DriverName, ":memory:")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	_, err = db.Begin()
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindValidation, qerr.Kind)
+}
+
+func TestDriverDSN(t *testing.T) {
+	// file databases run on a session, so they support transactions
+	file := openDSN(filepath.Join(t.TempDir(), "foo.db"))
+	defer file.Close()
+	assert.NotNil(t, file.pool)
+
+	memory := openDSN(":memory:")
+	defer memory.Close()
+	assert.Equal(t, "", memory.Name)
+	assert.Nil(t, memory.pool)
+}
+
+// TestDriverConformance runs the database/sql api against duckdb
+func TestDriverConformance(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "conformance.db")
+	db, err := sql.Open(DriverName, file)
+	assert.Nil(t, err)
+	defer db.Close()
+	if !assert.Nil(t, db.Ping()) {
+		return
+	}
+
+	_, err = db.Exec("CREATE TABLE people (id INTEGER, name VARCHAR, score DOUBLE, born DATE, tags VARCHAR[])")
+	assert.Nil(t, err)
+
+	t.Run("exec", func(t *testing.T) {
+		res, err := db.Exec("INSERT INTO people VALUES ($1, $2, $3, $4, $5), (2, NULL, NULL, NULL, NULL)",
+			1, "O'Brien", 1.5, time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC), []string{"a", "b"})
+		assert.Nil(t, err)
+		affected, err := res.RowsAffected()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), affected)
+	})
+
+	t.Run("query row", func(t *testing.T) {
+		var name string
+		var score float64
+		var born time.Time
+		var tags []byte
+		err := db.QueryRow("SELECT name, score, born, tags FROM people WHERE id = $1", 1).Scan(&name, &score, &born, &tags)
+		assert.Nil(t, err)
+		assert.Equal(t, "O'Brien", name)
+		assert.Equal(t, 1.5, score)
+		assert.Equal(t, 1990, born.Year())
+		assert.Equal(t, `["a","b"]`, string(tags))
+	})
+
+	t.Run("nulls", func(t *testing.T) {
+		var name sql.NullString
+		var score sql.NullFloat64
+		err := db.QueryRow("SELECT name, score FROM people WHERE id = 2").Scan(&name, &score)
+		assert.Nil(t, err)
+		assert.False(t, name.Valid)
+		assert.False(t, score.Valid)
+	})
+
+	t.Run("no rows", func(t *testing.T) {
+		var id int
+		err := db.QueryRow("SELECT id FROM people WHERE id = $1", 42).Scan(&id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("named", func(t *testing.T) {
+		var count int
+		err := db.QueryRow("SELECT count(*) FROM people WHERE id >= $min", sql.Named("min", 1)).Scan(&count)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("prepare", func(t *testing.T) {
+		stmt, err := db.Prepare("SELECT name FROM people WHERE id = $1")
+		assert.Nil(t, err)
+		defer stmt.Close()
+		var name sql.NullString
+		assert.Nil(t, stmt.QueryRow(1).Scan(&name))
+		assert.Equal(t, "O'Brien", name.String)
+		assert.Nil(t, stmt.QueryRow(2).Scan(&name))
+		assert.False(t, name.Valid)
+	})
+
+	t.Run("tx commit", func(t *testing.T) {
+		tx, err := db.Begin()
+		assert.Nil(t, err)
+		_, err = tx.Exec("INSERT INTO people (id, name) VALUES (3, 'c')")
+		assert.Nil(t, err)
+
+		// the transaction sees its own changes. The only session is held by the
+		// transaction, so other statements fail until it ends.
+		var count int
+		assert.Nil(t, tx.QueryRow("SELECT count(*) FROM people").Scan(&count))
+		assert.Equal(t, 3, count)
+		assert.NotNil(t, db.QueryRow("SELECT count(*) FROM people").Scan(&count))
+
+		assert.Nil(t, tx.Commit())
+		assert.Nil(t, db.QueryRow("SELECT count(*) FROM people").Scan(&count))
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("tx rollback", func(t *testing.T) {
+		tx, err := db.Begin()
+		assert.Nil(t, err)
+		_, err = tx.Exec("DELETE FROM people")
+		assert.Nil(t, err)
+		assert.Nil(t, tx.Rollback())
+
+		var count int
+		assert.Nil(t, db.QueryRow("SELECT count(*) FROM people").Scan(&count))
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := db.Query("SELECT * FROM missing")
+		var qerr *QueryError
+		assert.True(t, errors.As(err, &qerr))
+		assert.Equal(t, KindQuery, qerr.Kind)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := db.QueryContext(ctx, "SELECT 1")
+		assert.NotNil(t, err)
+	})
+}
@@ -2,18 +2,22 @@ package duck
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
-	"github.com/grafana/grafana-plugin-sdk-go/data/framestruct"
 	"github.com/hairyhenderson/go-which"
-	"github.com/iancoleman/orderedmap"
 	"github.com/jeremywohl/flatten"
 	"github.com/scottlepp/go-duck/duck/data"
 )
@@ -23,28 +27,123 @@ var logger = log.DefaultLogger
 type Dirs map[string]string
 
 type DuckDB struct {
-	Name          string
-	mode          string
-	format        string
-	exe           string
-	chunk         int
-	cacheDuration int
-	cache         cache
-	docker        bool
-	image         string
+	Name           string
+	mode           string
+	format         string
+	exe            string
+	chunk          int
+	cacheDuration  int
+	fingerprint    Fingerprint
+	timeout        int
+	sessions       int
+	sessionTimeout int
+	cache          Cache
+	ownCache       bool
+	results        *resultCache
+	flight         flight
+	docker         bool
+	image          string
+	runtime        string
+	volumes        []string
+	env            []string
+	resultFormat   string
+	parquet        data.ParquetOpts
+	pool           *pool
+	reuseContainer bool
+	container      *container
+	stream         bool
+	restoreLabels  bool
+	rowFormat      string
+	rowBuffer      int
+	extensions     extensions
 }
 
 type Opts struct {
-	Mode          string
-	Format        string
-	Chunk         int
+	// Mode is the duckdb output mode of Query and RunCommands: "json" (default), "jsonlines", "csv", "list",
+	// "line", "markdown", "html", "box" or "table". See ParseOutput and Result.Render.
+	Mode string
+	// Format is how frames are written for duckdb to read: "parquet" (default), "arrow" (Arrow IPC,
+	// also known as Feather, read with the nanoarrow extension, which is installed by the first query), "csv" or "ndjson"
+	Format string
+	Chunk  int
+	// Exe is the duckdb cli. In docker mode it is the cli in the image, which is run instead of its entrypoint.
 	Exe           string
 	CacheDuration int
 	Docker        bool
 	Image         string
+	// Runtime is the container runtime used in docker mode: "docker" (default), "podman" or "nerdctl"
+	Runtime string
+	// Volumes are extra volumes mounted in docker mode, in the "host:container" form of docker run -v
+	Volumes []string
+	// Env are environment variables set in docker mode, in the KEY=VALUE form of docker run -e
+	Env []string
+	// ReuseContainer keeps one container running in docker mode and runs each call in it
+	// with exec, instead of starting a new container per call. The container is removed by Close.
+	ReuseContainer bool
+	// Sessions is the number of long running duckdb processes to keep open.
+	// When zero a new process is started for every call.
+	Sessions int
+	// Compression is the parquet codec used for frames: "snappy" (default), "zstd", "gzip", "brotli" or "none"
+	Compression string
+	// RowGroupSize is the maximum number of rows in a parquet row group
+	RowGroupSize int
+	// Dictionary sets which parquet columns are dictionary encoded: "strings" (default), "all" or "none"
+	Dictionary string
+	// DisableStatistics stops parquet column statistics being written
+	DisableStatistics bool
+	// ResultFormat is how QueryFramesToFrames reads results from duckdb, "json" (default) or "parquet".
+	// Parquet is faster and keeps the column types for large results.
+	ResultFormat string
+	// Timeout is the number of seconds a call to duckdb may run before it is stopped
+	// with ErrTimeout. Zero means no limit other than the deadline of the context.
+	Timeout int
+	// SessionTimeout is the number of seconds a session may take to run a batch of commands
+	// before it is considered stuck and replaced, failing the call with ErrTimeout.
+	// Defaults to 300, a negative value means no limit.
+	SessionTimeout int
+	// Fingerprint identifies the frames in the cache key. Defaults to ContentFingerprint,
+	// use VersionFingerprint for frames that are too large to hash.
+	Fingerprint Fingerprint
+	// Cache replaces the default cache of frame parquet files. It is not closed by Close.
+	Cache Cache
+	// CacheMaxEntries is the number of queries the default cache keeps frames for
+	CacheMaxEntries int
+	// CacheMaxBytes is the size of the parquet files the default cache keeps
+	CacheMaxBytes int64
+	// ResultCacheDuration is the number of seconds the frames returned by QueryFramesToFrames
+	// are kept, so the same query on the same frames doesn't run duckdb again. Zero disables it.
+	ResultCacheDuration int
+	// ResultCacheMaxEntries is the number of result frames kept
+	ResultCacheMaxEntries int
+	// ResultCacheMaxRows is the total number of rows of the result frames kept
+	ResultCacheMaxRows int
+	// Stream writes frames to duckdb through named pipes instead of files, so frame data is never
+	// written to disk. The frame cache is not used. Only supported on unix.
+	Stream bool
+	// RestoreLabels turns result columns named after labels of the input frames back into labels
+	// of the value fields, when the results are not a time series. Wide time series input frames
+	// with a field per series are converted to long first, with a row for each series and time.
+	RestoreLabels bool
+	// CacheDir is the directory the default cache keeps parquet files in. The cached frames are
+	// reused after a restart until they expire. Parquet files left in the temp dir are removed.
+	CacheDir string
+	// RowFormat is the format QueryRows reads rows in: "jsonlines" (default) or "csv"
+	RowFormat string
+	// RowBuffer is the number of rows QueryRows reads ahead of the caller. duckdb is paused
+	// while they are not read. The default is 0, so rows are read as they are needed.
+	RowBuffer int
 }
 
+// ErrTimeout is returned when a query runs longer than Opts.Timeout or the deadline of its context
+var ErrTimeout = errors.New("duckdb query timed out")
+
+var containerSeq uint64
+
 const newline = "\n"
+
+// defaultSessionTimeout is the number of seconds a session may take to run a batch of commands
+const defaultSessionTimeout = 300
+
 const duckdbImage = "datacatering/duckdb:v1.0.0"
 
 var tempDir = getTempDir()
@@ -57,16 +156,31 @@ func NewInMemoryDB(opts ...Opts) *DuckDB {
 // NewDuckDB creates a new DuckDB
 func NewDuckDB(name string, opts ...Opts) *DuckDB {
 	db := DuckDB{
-		Name:   name,
-		mode:   "json",
-		format: "parquet",
-	}
+		Name:           name,
+		mode:           "json",
+		format:         "parquet",
+		resultFormat:   "json",
+		rowFormat:      RowFormatJSONLines,
+		sessionTimeout: defaultSessionTimeout,
+		fingerprint:    ContentFingerprint,
+		image:          duckdbImage,
+		runtime:        "docker",
+	}
+	cacheOpts := CacheOpts{}
+	resultTTL, resultEntries, resultRows := 0, 0, 0
 	for _, opt := range opts {
 		if opt.Mode != "" {
+			if parsers[opt.Mode] == nil && renderers[opt.Mode] == nil {
+				logger.Warn("unsupported output mode, its output can not be parsed", "mode", opt.Mode)
+			}
 			db.mode = opt.Mode
 		}
 		if opt.Format != "" {
-			db.format = opt.Format
+			if data.Extension(opt.Format) == "" {
+				logger.Warn("unsupported frame format, using parquet", "format", opt.Format)
+			} else {
+				db.format = opt.Format
+			}
 		}
 		if opt.Exe != "" {
 			db.exe = opt.Exe
@@ -77,11 +191,85 @@ func NewDuckDB(name string, opts ...Opts) *DuckDB {
 		if opt.CacheDuration > 0 {
 			db.cacheDuration = opt.CacheDuration
 		}
-		db.image = duckdbImage
+		if opt.Fingerprint != nil {
+			db.fingerprint = opt.Fingerprint
+		}
+		if opt.Cache != nil {
+			db.cache = opt.Cache
+		}
+		if opt.CacheMaxEntries > 0 {
+			cacheOpts.MaxEntries = opt.CacheMaxEntries
+		}
+		if opt.CacheMaxBytes > 0 {
+			cacheOpts.MaxBytes = opt.CacheMaxBytes
+		}
+		if opt.CacheDir != "" {
+			cacheOpts.Dir = opt.CacheDir
+		}
+		if opt.ResultCacheDuration > 0 {
+			resultTTL = opt.ResultCacheDuration
+		}
+		if opt.ResultCacheMaxEntries > 0 {
+			resultEntries = opt.ResultCacheMaxEntries
+		}
+		if opt.ResultCacheMaxRows > 0 {
+			resultRows = opt.ResultCacheMaxRows
+		}
 		if opt.Image != "" {
 			db.image = opt.Image
 		}
 		db.docker = opt.Docker
+		if opt.Runtime != "" {
+			db.runtime = opt.Runtime
+		}
+		if len(opt.Volumes) > 0 {
+			db.volumes = opt.Volumes
+		}
+		if len(opt.Env) > 0 {
+			db.env = opt.Env
+		}
+		if opt.ReuseContainer {
+			db.reuseContainer = true
+		}
+		if opt.Stream {
+			db.stream = true
+		}
+		if opt.RestoreLabels {
+			db.restoreLabels = true
+			db.parquet.LongSeries = true
+		}
+		if opt.RowFormat != "" {
+			db.rowFormat = rowFormat(opt.RowFormat)
+		}
+		if opt.RowBuffer > 0 {
+			db.rowBuffer = opt.RowBuffer
+		}
+		if opt.Compression != "" {
+			db.parquet.Compression = opt.Compression
+		}
+		if opt.RowGroupSize > 0 {
+			db.parquet.RowGroupSize = int64(opt.RowGroupSize)
+		}
+		if opt.Dictionary != "" {
+			db.parquet.Dictionary = opt.Dictionary
+		}
+		if opt.DisableStatistics {
+			db.parquet.DisableStatistics = true
+		}
+		if opt.ResultFormat != "" {
+			db.resultFormat = opt.ResultFormat
+		}
+		if opt.Timeout > 0 {
+			db.timeout = opt.Timeout
+		}
+		if opt.Sessions > 0 {
+			db.sessions = opt.Sessions
+		}
+		if opt.SessionTimeout > 0 {
+			db.sessionTimeout = opt.SessionTimeout
+		} else if opt.SessionTimeout < 0 {
+			db.sessionTimeout = 0
+		}
 	}
 
 	// Find the executable if it is not configured
@@ -91,33 +279,139 @@ func NewDuckDB(name string, opts ...Opts) *DuckDB {
 			db.exe = "/usr/local/bin/duckdb"
 		}
 	}
-	db.cache = cache{}
+	if db.cache == nil && cacheOpts.Dir != "" {
+		dir, err := cacheDir(cacheOpts.Dir)
+		if err != nil {
+			logger.Error("failed to create cache directory, files are not kept", "dir", cacheOpts.Dir, "error", err)
+			cacheOpts.Dir = ""
+		} else {
+			cacheOpts.Dir = dir
+			db.parquet.Dir = dir
+			cleanTempDirsOnce.Do(func() {
+				go cleanTempDirs(tempDir, time.Hour)
+			})
+		}
+	}
+	if db.cache == nil && (db.cacheDuration > 0 || cacheOpts.MaxEntries > 0 || cacheOpts.MaxBytes > 0 || cacheOpts.Dir != "") {
+		cacheOpts.TTL = db.cacheDuration
+		db.cache = NewCache(cacheOpts)
+		db.ownCache = true
+	}
+	if resultTTL > 0 {
+		db.results = newResultCache(resultTTL, resultEntries, resultRows)
+	}
+
+	if db.docker && db.reuseContainer {
+		options, dbPath := db.dockerOptions()
+		db.container = newContainer(cliRuntime{bin: db.runtime}, options, db.image, db.exe, dbPath)
+	}
+
+	if db.sessions > 0 {
+		// duckdb only allows one process to open a database file for writing
+		if db.Name != "" && db.sessions > 1 {
+			logger.Warn("file based databases only support a single session", "name", db.Name, "sessions", db.sessions)
+			db.sessions = 1
+		}
+		timeout := time.Duration(db.sessionTimeout) * time.Second
+		db.pool = newPool(db.sessions, timeout, func() (*session, error) {
+			s, err := startSession(db.command)
+			if err != nil {
+				return nil, newError(KindExecutable, "failed to start duckdb session: "+err.Error(), err)
+			}
+			return s, nil
+		})
+	}
 	return &db
 }
 
 // RunCommands runs a series of of sql commands against duckdb
 func (d *DuckDB) RunCommands(commands []string) (string, error) {
-	return d.runCommands(commands)
+	return d.RunCommandsContext(context.Background(), commands)
+}
+
+// RunCommandsContext runs a series of sql commands against duckdb.
+// The duckdb process is killed if the context is canceled or its deadline is exceeded.
+func (d *DuckDB) RunCommandsContext(ctx context.Context, commands []string) (string, error) {
+	return d.runCommands(ctx, commands)
 }
 
 // Query runs a query against the database. For Databases that are NOT in-memory.
 func (d *DuckDB) Query(query string) (string, error) {
-	return d.RunCommands([]string{query})
+	return d.QueryContext(context.Background(), query)
+}
+
+// QueryContext runs a query against the database, stopping it when the context is done.
+func (d *DuckDB) QueryContext(ctx context.Context, query string) (string, error) {
+	return d.RunCommandsContext(ctx, []string{query})
 }
 
 // QueryFrame will load a dataframe into a view named RefID, and run the query against that view
 func (d *DuckDB) QueryFrames(name string, query string, frames []*sdk.Frame) (string, bool, error) {
-	err := d.validate(query)
+	return d.QueryFramesContext(context.Background(), name, query, frames)
+}
+
+// QueryFramesContext is QueryFrames with cancellation. The parquet files are removed if the query is canceled.
+func (d *DuckDB) QueryFramesContext(ctx context.Context, name string, query string, frames []*sdk.Frame) (string, bool, error) {
+	return d.queryFrames(ctx, name, query, frames, outputRows, "")
+}
+
+// queryFrames runs the query against the frames, returning the results in the requested output.
+// Concurrent calls for the same query on the same frames share one conversion and query.
+func (d *DuckDB) queryFrames(ctx context.Context, name string, query string, frames []*sdk.Frame, output queryOutput, fingerprint string) (string, bool, error) {
+	if fingerprint == "" && d.cachesFiles() {
+		var err error
+		fingerprint, err = d.fingerprint(frames)
+		if err != nil {
+			return "", false, conversionError(fmt.Errorf("failed to fingerprint frames: %w", err))
+		}
+	}
+	key := fmt.Sprintf("query:%d:%s:%s:%s", output, flightFrames(fingerprint, frames), name, query)
+	v, shared, err := d.flight.do(ctx, key, func(ctx context.Context) (any, error) {
+		err := d.validate(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		data := FrameData{
+			cache:       d.cache,
+			db:          d,
+			output:      output,
+			fingerprint: fingerprint,
+		}
+		res, cached, err := data.Query(ctx, name, query, frames)
+		return frameResult{res: res, cached: cached}, err
+	})
+	if shared {
+		logger.Debug("shared frame query with a running call", "name", name)
+	}
 	if err != nil {
 		return "", false, err
 	}
-	data := FrameData{
-		cacheDuration: d.cacheDuration,
-		cache:         &d.cache,
-		db:            d,
-	}
+	r := v.(frameResult)
+	return r.res, r.cached, nil
+}
+
+type frameResult struct {
+	res    string
+	cached bool
+}
+
+// cachesFiles reports whether the converted frames are cached, keyed by their fingerprint
+func (d *DuckDB) cachesFiles() bool {
+	return d.cache != nil && !d.stream
+}
 
-	return data.Query(name, query, frames)
+// flightFrames identifies the frames in a single flight key. Without a cache there is no
+// fingerprint, and hashing the values only to share calls isn't worth it, so the frames
+// themselves are used. They can't be reused while a call on them is running.
+func flightFrames(fingerprint string, frames []*sdk.Frame) string {
+	if fingerprint != "" {
+		return fingerprint
+	}
+	var b strings.Builder
+	for _, frame := range frames {
+		fmt.Fprintf(&b, "%p,", frame)
+	}
+	return b.String()
 }
 
 func wipe(dirs map[string]string) {
@@ -130,22 +424,45 @@ func wipe(dirs map[string]string) {
 }
 
 func (d *DuckDB) QueryFramesToFrames(name string, query string, frames []*sdk.Frame) (*sdk.Frame, error) {
-	err := d.validate(query)
-	if err != nil {
-		return nil, err
-	}
+	return d.QueryFramesToFramesContext(context.Background(), name, query, frames)
+}
 
-	f := &sdk.Frame{}
-	res, cached, err := d.QueryFrames(name, query, frames)
-	if err != nil {
-		return nil, err
+// QueryFramesToFramesContext is QueryFramesToFrames with cancellation.
+func (d *DuckDB) QueryFramesToFramesContext(ctx context.Context, name string, query string, frames []*sdk.Frame) (*sdk.Frame, error) {
+	var fingerprint string
+	if d.results != nil || d.cachesFiles() {
+		var err error
+		fingerprint, err = d.fingerprint(frames)
+		if err != nil {
+			return nil, conversionError(fmt.Errorf("failed to fingerprint frames: %w", err))
+		}
+	}
+	key := CacheKey{Name: name, Query: query, Fingerprint: fingerprint}
+	if d.results != nil {
+		if f, ok := d.results.get(key); ok {
+			f.AppendNotices(sdk.Notice{
+				Severity: sdk.NoticeSeverityInfo,
+				Text:     "Results retrieved from cache",
+			})
+			return f, nil
+		}
 	}
 
-	err = resultsToFrame(name, res, f, frames)
+	// concurrent calls for the same query on the same frames share the query and conversion
+	flightKey := fmt.Sprintf("frame:%s:%s:%s", flightFrames(fingerprint, frames), name, query)
+	v, _, err := d.flight.do(ctx, flightKey, func(ctx context.Context) (any, error) {
+		f, cached, err := d.queryFramesToFrame(ctx, name, query, frames, fingerprint)
+		if err == nil && d.results != nil {
+			d.results.set(key, f)
+		}
+		return frameToFrameResult{frame: f, cached: cached}, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	if cached {
+	r := v.(frameToFrameResult)
+
+	if r.cached {
 		for _, frame := range frames {
 			if frame.Meta == nil {
 				frame.Meta = &sdk.FrameMeta{}
@@ -157,11 +474,115 @@ func (d *DuckDB) QueryFramesToFrames(name string, query string, frames []*sdk.Fr
 			frame.Meta.Notices = append(frame.Meta.Notices, notice)
 		}
 	}
-	return f, nil
+	// the frame may be shared with other callers, so each gets a copy they can add notices to
+	return copyFrame(r.frame), nil
+}
+
+// QueryFramesToFrameList runs the query against the frames, and returns a frame for each set of labels
+// in the results. Results are split by the label columns, or the labels of time series fields.
+// When no labels are given, the labels of the input frames are used.
+func (d *DuckDB) QueryFramesToFrameList(name string, query string, frames []*sdk.Frame, labels ...string) (sdk.Frames, error) {
+	return d.QueryFramesToFrameListContext(context.Background(), name, query, frames, labels...)
+}
+
+// QueryFramesToFrameListContext is QueryFramesToFrameList with cancellation.
+func (d *DuckDB) QueryFramesToFrameListContext(ctx context.Context, name string, query string, frames []*sdk.Frame, labels ...string) (sdk.Frames, error) {
+	f, err := d.QueryFramesToFramesContext(ctx, name, query, frames)
+	if err != nil {
+		return nil, err
+	}
+	return splitByLabels(f, frames, labels), nil
+}
+
+type frameToFrameResult struct {
+	frame  *sdk.Frame
+	cached bool
+}
+
+// queryFramesToFrame runs the query and converts the results to a frame
+func (d *DuckDB) queryFramesToFrame(ctx context.Context, name string, query string, frames []*sdk.Frame, fingerprint string) (*sdk.Frame, bool, error) {
+	f := &sdk.Frame{}
+	var cached bool
+	if d.resultFormat == "parquet" {
+		res, c, err := d.queryFrames(ctx, name, query, frames, outputParquet, fingerprint)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := parquetToFrame(name, res, f); err != nil {
+			return nil, false, conversionError(err)
+		}
+		cached = c
+	} else {
+		res, c, err := d.queryFrames(ctx, name, query, frames, outputTyped, fingerprint)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := resultsToFrame(name, res, f, frames); err != nil {
+			return nil, false, conversionError(err)
+		}
+		cached = c
+	}
+	if d.restoreLabels {
+		restoreLabels(f, frames)
+	}
+	return f, cached, nil
+}
+
+// Invalidate removes the cached parquet files and results of frames queried with the name
+func (d *DuckDB) Invalidate(name string) {
+	if d.cache != nil {
+		d.cache.Invalidate(name)
+	}
+	if d.results != nil {
+		d.results.invalidate(name)
+	}
+}
+
+// Purge removes all cached parquet files and results
+func (d *DuckDB) Purge() {
+	if d.cache != nil {
+		d.cache.Purge()
+	}
+	if d.results != nil {
+		d.results.purge()
+	}
+}
+
+// CacheStats returns the counters of the frame cache
+func (d *DuckDB) CacheStats() CacheStats {
+	if d.cache == nil {
+		return CacheStats{}
+	}
+	return d.cache.Stats()
+}
+
+// ResultCacheStats returns the counters of the result cache
+func (d *DuckDB) ResultCacheStats() CacheStats {
+	if d.results == nil {
+		return CacheStats{}
+	}
+	return d.results.statistics()
+}
+
+// Close stops any long running duckdb sessions and the reused container, and removes cached files
+func (d *DuckDB) Close() error {
+	if d.pool != nil {
+		d.pool.close()
+	}
+	if d.ownCache {
+		d.cache.Close()
+	}
+	if d.container != nil {
+		return d.container.stop()
+	}
+	return nil
 }
 
 // Destroy will remove database files created by duckdb
 func (d *DuckDB) Destroy() error {
+	if err := d.Close(); err != nil {
+		return err
+	}
 	if d.Name != "" {
 		return os.Remove(d.Name)
 	}
@@ -172,59 +593,59 @@ func resultsToFrame(name string, res string, f *sdk.Frame, frames []*sdk.Frame)
 	if res == "" {
 		return nil
 	}
-	var results []map[string]any
-	err := json.Unmarshal([]byte(res), &results)
+	columns, results, err := decodeResults(res)
 	if err != nil {
 		logger.Error("error unmarshalling results", "error", err)
 		return err
 	}
 
-	data.ConvertDateFields(results)
-
-	converters := data.Converters(frames)
-	resultsFrame, err := framestruct.ToDataFrame(name, results, converters...)
-
+	resultsFrame, err := data.ToFrame(name, columns, results)
 	if err != nil {
 		logger.Error("error converting results to frame", "error", err)
 		return err
 	}
 
-	// Order the fields in the same order as the source frame:
-	// Build a slice of ordered keys
+	f.Name = resultsFrame.Name
+	f.Fields = resultsFrame.Fields
+	f.Meta = resultsFrame.Meta
+	f.RefID = resultsFrame.RefID
 
-	var orderedKeys []string
-	var temp []orderedmap.OrderedMap
-	err = json.Unmarshal([]byte(res), &temp)
-	if err == nil {
-		orderedKeys = temp[0].Keys()
-	}
+	setFrameType(f)
 
-	// Create a map of column names to indexes
-	columnIndex := make(map[string]int)
-	for i, field := range resultsFrame.Fields {
-		columnIndex[field.Name] = i
-	}
-	// Add columns to the DataFrame
-	for _, key := range orderedKeys {
-		i := columnIndex[key]
-		f.Fields = append(f.Fields, resultsFrame.Fields[i])
+	return nil
+}
+
+// parquetToFrame reads the results written by a parquet query, and removes the file
+func parquetToFrame(name string, file string, f *sdk.Frame) error {
+	defer wipe(Dirs{"results": filepath.Dir(file)})
+
+	resultsFrame, err := data.FromParquet(file)
+	if err != nil {
+		logger.Error("error reading results", "file", file, "error", err)
+		return err
 	}
 
-	f.Name = resultsFrame.Name
+	f.Name = name
+	f.Fields = resultsFrame.Fields
 	f.Meta = resultsFrame.Meta
-	f.RefID = resultsFrame.RefID
 
+	setFrameType(f)
+	return nil
+}
+
+// setFrameType converts long time series to wide, and marks wide time series
+func setFrameType(f *sdk.Frame) {
 	kind := f.TimeSeriesSchema().Type
 	if kind == sdk.TimeSeriesTypeLong {
 		fillMode := &sdk.FillMissing{Mode: sdk.FillModeNull}
 		frame, err := sdk.LongToWide(f, fillMode)
 		if err != nil {
 			logger.Warn("could not convert frame long to wide", "error", err)
-			return nil
+			return
 		}
 		f.Fields = frame.Fields
 		f.Meta = frame.Meta
-		return nil
+		return
 	}
 
 	if kind == sdk.TimeSeriesTypeWide {
@@ -233,71 +654,230 @@ func resultsToFrame(name string, res string, f *sdk.Frame, frames []*sdk.Frame)
 		}
 		f.Meta.Type = sdk.FrameTypeTimeSeriesWide
 	}
+}
 
-	// TODO - appending to field names for now
-	// applyLabels(*resultsFrame, frames)
+// decodeResults reads the output of DESCRIBE followed by the rows of the query
+func decodeResults(res string) ([]data.Column, []map[string]any, error) {
+	decoder := json.NewDecoder(strings.NewReader(res))
+	decoder.UseNumber()
 
-	return nil
+	var columns []data.Column
+	err := decoder.Decode(&columns)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding result columns: %w", err)
+	}
+
+	// duckdb prints nothing when there are no rows
+	var results []map[string]any
+	err = decoder.Decode(&results)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("error decoding result rows: %w", err)
+	}
+	return columns, results, nil
 }
 
-func (d *DuckDB) runCommands(commands []string) (string, error) {
+func (d *DuckDB) runCommands(ctx context.Context, commands []string) (string, error) {
+	return d.runCommandsOn(ctx, nil, commands)
+}
+
+// runCommandsOn runs the commands on the session, which is taken from the pool when it is nil
+func (d *DuckDB) runCommandsOn(ctx context.Context, s *session, commands []string) (string, error) {
+	out, lines, err := d.runBatch(ctx, s, commands)
+	if err != nil {
+		return "", err
+	}
+	if out.stderr != "" {
+		logger.Error("error running command", "cmd", strings.Join(commands, newline), "error", out.stderr)
+		return "", parseError(out.stderr, lines)
+	}
+	return out.stdout, nil
+}
+
+// runBatch runs the commands, and returns what duckdb printed with the input line each command
+// started on. Errors printed by duckdb are returned in the batch, other failures as an error.
+// Pooled databases run them on the session s, or on the next free session when s is nil.
+func (d *DuckDB) runBatch(ctx context.Context, s *session, commands []string) (batch, []int, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(d.timeout)*time.Second)
+		defer cancel()
+	}
+	if d.pool != nil {
+		return d.runSession(ctx, s, commands)
+	}
+
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 
+	script := d.script(commands)
+	lines := commandLines(commands, 1)
+
+	cmd, err := d.command(ctx)
+	if err != nil {
+		return batch{}, nil, newError(KindExecutable, "failed to start container: "+err.Error(), err)
+	}
+	cmd.Stdin = bytes.NewReader(script)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		logger.Error("command stopped", "cmd", string(script), "error", ctx.Err())
+		return batch{}, nil, contextError(ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || strings.TrimSpace(stderr.String()) == "") {
+		// duckdb could not run the commands, rather than printing errors for them
+		d.checkContainer()
+		logger.Error("error running command", "cmd", string(script), "stderr", stderr.String(), "error", err)
+		return batch{}, nil, commandError(err, stderr.String(), lines)
+	}
+	if err != nil && d.docker {
+		if cerr := containerError(err, stderr.String()); cerr != nil {
+			d.checkContainer()
+			logger.Error("error running container", "cmd", string(script), "stderr", stderr.String(), "error", err)
+			return batch{}, nil, cerr
+		}
+	}
+	return batch{stdout: stdout.String(), stderr: stderr.String(), line: 1}, lines, nil
+}
+
+// runSession runs the commands on s, or on the next free pooled duckdb process when s is nil
+func (d *DuckDB) runSession(ctx context.Context, s *session, commands []string) (batch, []int, error) {
+	// a session keeps reading after each command, so every statement must be terminated
+	terminated := make([]string, len(commands))
+	for i, c := range commands {
+		if unterminated(c) {
+			err := newError(KindValidation, fmt.Sprintf("command %d has an unterminated string or comment", i), nil)
+			err.Statement = i
+			return batch{}, nil, err
+		}
+		terminated[i] = terminate(c)
+	}
+	script := d.script(terminated)
+
+	var out batch
+	var err error
+	if s != nil {
+		out, err = s.run(ctx, script, d.pool.timeout)
+	} else {
+		out, err = d.pool.run(ctx, script)
+	}
+	if ctx.Err() != nil {
+		logger.Error("command stopped", "cmd", string(script), "error", ctx.Err())
+		return batch{}, nil, contextError(ctx.Err())
+	}
+	if err != nil {
+		d.checkContainer()
+		logger.Error("error running command", "cmd", string(script), "error", err)
+		return batch{}, nil, sessionError(err)
+	}
+	return out, commandLines(terminated, out.line), nil
+}
+
+func (d *DuckDB) script(commands []string) []byte {
 	var b bytes.Buffer
 	b.Write([]byte(fmt.Sprintf(".mode %s %s", d.mode, newline)))
 	for _, c := range commands {
 		cmd := fmt.Sprintf("%s %s", c, newline)
 		b.Write([]byte(cmd))
 	}
+	return b.Bytes()
+}
 
-	var cmd *exec.Cmd
+// command creates the duckdb process, which is killed when the context is done
+func (d *DuckDB) command(ctx context.Context) (*exec.Cmd, error) {
+	if d.container != nil {
+		// duckdb is killed in the container on cancel, along with the exec client
+		return d.container.command(ctx)
+	}
 	if d.docker {
-		volume := fmt.Sprintf("%s:%s", tempDir, tempDir)
-		logger.Debug("running command in docker", "volume", volume, "image", duckdbImage)
-		cmd = exec.Command("docker", "run", "-i", "-v", volume, duckdbImage)
-	} else {
-		cmd = exec.Command(d.exe, d.Name)
+		name := fmt.Sprintf("go-duck-%d-%d", os.Getpid(), atomic.AddUint64(&containerSeq, 1))
+		args := d.dockerArgs(name)
+		logger.Debug("running command in docker", "runtime", d.runtime, "args", args)
+		cmd := exec.CommandContext(ctx, d.runtime, args...)
+		cmd.Cancel = func() error {
+			// killing the docker client leaves the container running
+			if err := exec.Command(d.runtime, "kill", name).Run(); err != nil {
+				logger.Warn("failed to kill container", "container", name, "error", err)
+			}
+			return cmd.Process.Kill()
+		}
+		return cmd, nil
 	}
-	cmd.Stdin = &b
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	return exec.CommandContext(ctx, d.exe, d.Name), nil
+}
 
-	err := cmd.Run()
-	if err != nil {
-		message := err.Error() + stderr.String()
-		logger.Error("error running command", "cmd", b.String(), "message", message, "error", err)
-		return "", errors.New(message)
-	}
-	if stderr.String() != "" {
-		logger.Error("error running command", "cmd", b.String(), "error", stderr.String())
-		return "", errors.New(stderr.String())
-	}
-	return stdout.String(), nil
-}
-
-// TODO
-
-// func applyLabels(resultsFrame sdk.Frame, sourceFrames []*sdk.Frame) {
-// 	for _, fld := range resultsFrame.Fields {
-// 		for _, f := range sourceFrames {
-// 			srcField := find(f, fld)
-// 			if srcField != nil {
-// 				fld.Labels = srcField.Labels
-// 				break
-// 			}
-// 		}
-// 	}
-// }
-
-// func find(f *sdk.Frame, fld *sdk.Field) *sdk.Field {
-// 	for _, sfld := range f.Fields {
-// 		if sfld.Name == fld.Name {
-// 			return sfld
-// 		}
-// 	}
-// 	return nil
-// }
+// checkContainer makes the next call check the reused container is still running
+func (d *DuckDB) checkContainer() {
+	if d.container != nil {
+		d.container.failed()
+	}
+}
+
+// dockerArgs returns the arguments to run duckdb in a container
+func (d *DuckDB) dockerArgs(name string) []string {
+	options, dbPath := d.dockerOptions()
+	args := append([]string{"run", "-i", "--rm", "--name", name}, options...)
+	args = append(args, d.image)
+	if dbPath != "" {
+		args = append(args, dbPath)
+	}
+	return args
+}
+
+// dockerOptions returns the volume, environment and entrypoint arguments for the container, and the
+// path of the database in it. The temp dir and cache dir are mounted for parquet files, and the directory
+// of a file based database is mounted at the same path so the database is kept on the host.
+func (d *DuckDB) dockerOptions() ([]string, string) {
+	args := []string{"-v", fmt.Sprintf("%s:%s", tempDir, tempDir)}
+
+	dbPath := ""
+	if d.Name != "" {
+		var err error
+		dbPath, err = filepath.Abs(d.Name)
+		if err != nil {
+			logger.Warn("could not find absolute path of database", "name", d.Name, "error", err)
+			dbPath = d.Name
+		}
+		dir := filepath.Dir(dbPath)
+		args = append(args, "-v", fmt.Sprintf("%s:%s", dir, dir))
+	}
+	if d.parquet.Dir != "" {
+		args = append(args, "-v", fmt.Sprintf("%s:%s", d.parquet.Dir, d.parquet.Dir))
+	}
+	for _, volume := range d.volumes {
+		args = append(args, "-v", volume)
+	}
+	for _, env := range d.env {
+		args = append(args, "-e", env)
+	}
+	if d.exe != "" {
+		args = append(args, "--entrypoint", d.exe)
+	}
+	return args, dbPath
+}
+
+// contextError marks deadline errors with ErrTimeout so timeouts can be told apart from other failures
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newError(KindTimeout, "", fmt.Errorf("%w: %w", ErrTimeout, err))
+	}
+	return newError(KindCanceled, "", err)
+}
+
+// terminate adds a semicolon to sql commands that are missing one. A semicolon in a comment
+// at the end of the command does not terminate it.
+func terminate(command string) string {
+	trimmed := strings.TrimSpace(command)
+	if trimmed == "" || strings.HasPrefix(trimmed, ".") {
+		return command
+	}
+	if _, last := scanSQL(command); last == ';' {
+		return command
+	}
+	return command + newline + ";"
+}
 
 func getTempDir() string {
 	temp := os.Getenv("TMPDIR")
@@ -313,24 +893,29 @@ const (
 	ERROR_MESSAGE = ".error_message"
 )
 
-func (d *DuckDB) validate(rawSQL string) error {
-	rawSQL = strings.Replace(rawSQL, "'", "''", -1)
-	cmd := fmt.Sprintf("SELECT json_serialize_sql('%s')", rawSQL)
-	ret, err := d.RunCommands([]string{cmd})
+func (d *DuckDB) validate(ctx context.Context, rawSQL string) error {
+	lit, err := stringLiteral(rawSQL)
+	if err != nil {
+		return validationError("invalid sql: %s", err.Error())
+	}
+	cmd := fmt.Sprintf("SELECT json_serialize_sql(%s)", lit)
+	// the ast is decoded from json, whatever the mode of the database
+	ret, err := d.RunCommandsContext(ctx, []string{".mode " + ModeJSON, cmd})
 	if err != nil {
 		logger.Error("error validating sql", "error", err.Error(), "sql", rawSQL, "cmd", cmd)
-		return fmt.Errorf("error validating sql: %s", err.Error())
+		return err
 	}
 
 	result := []map[string]any{}
 	err = json.Unmarshal([]byte(ret), &result)
 	if err != nil {
 		logger.Error("error converting json sql to ast", "error", err.Error(), "ret", ret)
-		return fmt.Errorf("error converting json to ast: %s", err.Error())
+		return validationError("error converting json to ast: %s", err.Error())
 	}
 
 	if len(result) == 0 {
 		logger.Error("no ast returned", "ret", ret)
+		return validationError("no ast returned: %s", ret)
 	}
 
 	var ast map[string]any
@@ -338,7 +923,7 @@ func (d *DuckDB) validate(rawSQL string) error {
 		validAst, ok := v.(map[string]any)
 		if !ok {
 			logger.Error("invalid sql", "sql", ret)
-			return fmt.Errorf("invalid sql: %s", ret)
+			return validationError("invalid sql: %s", ret)
 		}
 		ast = validAst
 		break
@@ -349,24 +934,24 @@ func (d *DuckDB) validate(rawSQL string) error {
 		errMsgBool, ok := errMsg.(bool)
 		if !ok {
 			logger.Error("error in ast", "error", ret)
-			return fmt.Errorf("error in ast: %v", ret)
+			return validationError("error in ast: %v", ret)
 		}
 		if errMsgBool {
 			logger.Error("error in ast", "error", ret)
-			return fmt.Errorf("error in ast: %v", ret)
+			return astError(ast, ret)
 		}
 	}
 
 	statements := ast["statements"]
 	if statements == nil {
 		logger.Error("no statements in ast", "ast", ast)
-		return fmt.Errorf("no statements in ast: %v", ast)
+		return validationError("no statements in ast: %v", ast)
 	}
 
 	flat, err := flatten.Flatten(ast, "", flatten.DotStyle)
 	if err != nil {
 		logger.Error("error flattening ast", "error", err.Error(), "ast", ast)
-		return fmt.Errorf("error flattening ast: %s", err.Error())
+		return validationError("error flattening ast: %s", err.Error())
 	}
 
 	for k, v := range flat {
@@ -374,21 +959,38 @@ func (d *DuckDB) validate(rawSQL string) error {
 			v, ok := v.(bool)
 			if ok && v {
 				logger.Error("error in sql", "error", k)
-				return fmt.Errorf("error flattening ast: %s", k)
+				return validationError("error flattening ast: %s", k)
 			}
 		}
 		if strings.Contains(k, "from_table.function.function_name") {
 			logger.Error("function not allowed", "function", v)
-			return fmt.Errorf("function not allowed: %s", v)
+			return validationError("function not allowed: %s", v)
 		}
 		if strings.HasSuffix(k, "from_table.table_name") {
 			v, ok := v.(string)
 			if ok && strings.Contains(v, ".") {
 				logger.Error("table names with . not allowed", "table", v)
-				return fmt.Errorf("table names with . not allowed: %s", v)
+				return validationError("table names with . not allowed: %s", v)
 			}
 		}
 	}
 
 	return nil
 }
+
+// astError returns the error json_serialize_sql reports for sql that can not be parsed,
+// such as {"error":true,"error_type":"parser","error_message":"syntax error ...","position":"7"}
+func astError(ast map[string]any, ret string) error {
+	message, ok := ast["error_message"].(string)
+	if !ok {
+		return validationError("error in ast: %v", ret)
+	}
+	qerr := newError(KindQuery, message, nil)
+	if errorType, ok := ast["error_type"].(string); ok && errorType != "" {
+		qerr.Class = strings.ToUpper(errorType[:1]) + errorType[1:] + " Error"
+	}
+	if position, err := strconv.Atoi(fmt.Sprint(ast["position"])); err == nil {
+		qerr.Position = position + 1
+	}
+	return qerr
+}
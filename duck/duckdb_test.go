@@ -1,7 +1,10 @@
 package duck
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"testing"
 	"time"
 
@@ -238,6 +241,31 @@ func TestQueryFrameIntoFrame(t *testing.T) {
 	fmt.Printf("GOT: %s", txt)
 }
 
+func TestQueryFramesToFrameList(t *testing.T) {
+	db := NewInMemoryDB()
+
+	ts := time.Date(2024, 2, 23, 9, 1, 54, 0, time.UTC)
+	cpuA := data.NewFrame("cpu a",
+		data.NewField("time", nil, []time.Time{ts, ts.Add(time.Minute)}),
+		data.NewField("value", data.Labels{"host": "a"}, []float64{1, 2}),
+	)
+	cpuA.RefID = "A"
+	cpuB := data.NewFrame("cpu b",
+		data.NewField("time", nil, []time.Time{ts, ts.Add(time.Minute)}),
+		data.NewField("value", data.Labels{"host": "b"}, []float64{3, 4}),
+	)
+	cpuB.RefID = "A"
+
+	frames, err := db.QueryFramesToFrameList("cpu", "select time, host, value * 2 as value from A order by time", []*data.Frame{cpuA, cpuB})
+	assert.Nil(t, err)
+
+	assert.Len(t, frames, 2)
+	assert.Equal(t, "cpu a", frames[0].Name)
+	assert.Equal(t, "cpu b", frames[1].Name)
+	assert.Equal(t, data.FrameTypeTimeSeriesMulti, frames[0].Meta.Type)
+	assert.Equal(t, data.Labels{"host": "b"}, frames[1].Fields[1].Labels)
+}
+
 func TestQueryFrameIntoFrameDocker(t *testing.T) {
 	db := NewInMemoryDB(Opts{Docker: true})
 
@@ -587,3 +615,297 @@ func TestTimeSeriesAggregate(t *testing.T) {
 // 	assert.Contains(t, txt, "A")
 // 	assert.Contains(t, txt, "B")
 // }
+
+func TestQueryContextTimeout(t *testing.T) {
+	db := NewInMemoryDB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := db.QueryContext(ctx, "SELECT count(*) FROM range(100000000) a, range(100000) b;")
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestQueryOptsTimeout(t *testing.T) {
+	db := NewInMemoryDB(Opts{Timeout: 1})
+
+	_, err := db.Query("SELECT count(*) FROM range(100000000) a, range(100000) b;")
+	assert.ErrorIs(t, err, ErrTimeout)
+}
+
+func TestQueryContextCanceled(t *testing.T) {
+	db := NewInMemoryDB()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := db.QueryContext(ctx, "SELECT count(*) FROM range(100000000) a, range(100000) b;")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrTimeout)
+}
+
+func TestQueryFramesCanceledRemovesParquet(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	db := NewInMemoryDB(Opts{CacheDuration: 5})
+
+	var values = []string{"test"}
+	frame := data.NewFrame("foo", data.NewField("value", nil, values))
+	frame.RefID = "foo"
+	frames := []*data.Frame{frame}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fd := FrameData{cache: db.cache, db: db}
+	_, _, err := fd.Query(ctx, "foo", "select * from foo", frames)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the parquet files are removed without waiting for the cache duration
+	assert.Eventually(t, func() bool {
+		entries, err := os.ReadDir(dir)
+		return err == nil && len(entries) == 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	key, err := fd.key("foo", "select * from foo", frames)
+	assert.Nil(t, err)
+	_, _, ok := db.cache.Get(key)
+	assert.False(t, ok)
+}
+
+func TestQueryFrameIntoFrameTypes(t *testing.T) {
+	db := NewInMemoryDB()
+
+	var values = []string{"2024"}
+	frame := data.NewFrame("foo", data.NewField("value", nil, values))
+	frame.RefID = "foo"
+	frames := []*data.Frame{frame}
+
+	query := `select value,
+		9223372036854775807::BIGINT as big,
+		true as flag,
+		1.5::DECIMAL(18,3) as amount,
+		DATE '2024-02-23' as day,
+		TIMESTAMP '2024-02-23 09:01:54' as ts,
+		INTERVAL 1 HOUR as duration,
+		[1, 2] as list
+		from foo`
+	model, err := db.QueryFramesToFrames("foo", query, frames)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, model.Rows())
+
+	expected := []data.FieldType{
+		data.FieldTypeNullableString,
+		data.FieldTypeNullableInt64,
+		data.FieldTypeNullableBool,
+		data.FieldTypeNullableFloat64,
+		data.FieldTypeNullableTime,
+		data.FieldTypeNullableTime,
+		data.FieldTypeNullableString,
+		data.FieldTypeNullableJSON,
+	}
+	for i, fieldType := range expected {
+		assert.Equal(t, fieldType, model.Fields[i].Type(), model.Fields[i].Name)
+	}
+
+	value, _ := model.Fields[0].ConcreteAt(0)
+	assert.Equal(t, "2024", value)
+
+	big, _ := model.Fields[1].ConcreteAt(0)
+	assert.Equal(t, int64(9223372036854775807), big)
+}
+
+func TestQueryFrameIntoFrameNoRows(t *testing.T) {
+	db := NewInMemoryDB()
+
+	var values = []string{"test"}
+	frame := data.NewFrame("foo", data.NewField("value", nil, values))
+	frame.RefID = "foo"
+	frames := []*data.Frame{frame}
+
+	model, err := db.QueryFramesToFrames("foo", "select * from foo where value = 'other'", frames)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, model.Rows())
+	assert.Equal(t, "value", model.Fields[0].Name)
+}
+
+func TestQueryFrameIntoFrameParquet(t *testing.T) {
+	db := NewInMemoryDB(Opts{ResultFormat: "parquet"})
+
+	tt := "2024-02-23 09:01:54"
+	dd, err := dateparse.ParseAny(tt)
+	assert.Nil(t, err)
+
+	frame := data.NewFrame("foo",
+		data.NewField("time", nil, []time.Time{dd}),
+		data.NewField("value", nil, []string{"2024"}),
+	)
+	frame.RefID = "foo"
+	frames := []*data.Frame{frame}
+
+	query := `select time, value,
+		9223372036854775807::BIGINT as big,
+		1.5::DECIMAL(18,3) as amount,
+		DATE '2024-02-23' as day,
+		[1, 2] as list
+		from foo`
+	model, err := db.QueryFramesToFrames("foo", query, frames)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, model.Rows())
+
+	expected := []data.FieldType{
+		data.FieldTypeNullableTime,
+		data.FieldTypeNullableString,
+		data.FieldTypeNullableInt64,
+		data.FieldTypeNullableFloat64,
+		data.FieldTypeNullableTime,
+		data.FieldTypeNullableJSON,
+	}
+	for i, fieldType := range expected {
+		assert.Equal(t, fieldType, model.Fields[i].Type(), model.Fields[i].Name)
+	}
+
+	ts, _ := model.Fields[0].ConcreteAt(0)
+	assert.True(t, dd.Equal(ts.(time.Time)))
+
+	big, _ := model.Fields[2].ConcreteAt(0)
+	assert.Equal(t, int64(9223372036854775807), big)
+}
+
+func benchmarkFrames(rows int) []*data.Frame {
+	times := make([]time.Time, rows)
+	values := make([]*float64, rows)
+	hosts := make([]string, rows)
+	start := time.Now()
+	for i := 0; i < rows; i++ {
+		v := float64(i)
+		times[i] = start.Add(time.Duration(i) * time.Second)
+		values[i] = &v
+		hosts[i] = fmt.Sprintf("host-%d", i%10)
+	}
+	frame := data.NewFrame("foo",
+		data.NewField("time", nil, times),
+		data.NewField("value", nil, values),
+		data.NewField("host", nil, hosts),
+	)
+	frame.RefID = "foo"
+	return []*data.Frame{frame}
+}
+
+func benchmarkQueryFramesToFrames(b *testing.B, format string) {
+	db := NewInMemoryDB(Opts{ResultFormat: format})
+	frames := benchmarkFrames(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := db.QueryFramesToFrames("foo", "select * from foo", frames)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQueryFramesToFramesJSON(b *testing.B) {
+	benchmarkQueryFramesToFrames(b, "json")
+}
+
+func BenchmarkQueryFramesToFramesParquet(b *testing.B) {
+	benchmarkQueryFramesToFrames(b, "parquet")
+}
+
+func TestDockerArgs(t *testing.T) {
+	db := NewDuckDB("/data/foo.db", Opts{
+		Docker:  true,
+		Image:   "duckdb/duckdb:latest",
+		Runtime: "podman",
+		Volumes: []string{"/host/files:/files:ro"},
+		Env:     []string{"TZ=UTC"},
+	})
+	assert.Equal(t, "podman", db.runtime)
+
+	args := db.dockerArgs("test")
+	expected := []string{
+		"run", "-i", "--rm", "--name", "test",
+		"-v", fmt.Sprintf("%s:%s", tempDir, tempDir),
+		"-v", "/data:/data",
+		"-v", "/host/files:/files:ro",
+		"-e", "TZ=UTC",
+		"duckdb/duckdb:latest",
+		"/data/foo.db",
+	}
+	assert.Equal(t, expected, args)
+}
+
+func TestDockerArgsInMemory(t *testing.T) {
+	db := NewInMemoryDB(Opts{Docker: true})
+	assert.Equal(t, "docker", db.runtime)
+
+	args := db.dockerArgs("test")
+	assert.Equal(t, duckdbImage, args[len(args)-1])
+}
+
+func TestDockerArgsExe(t *testing.T) {
+	db := NewInMemoryDB(Opts{Docker: true, Exe: "/opt/duckdb/duckdb"})
+	args := db.dockerArgs("test")
+	assert.Equal(t, []string{"--entrypoint", "/opt/duckdb/duckdb", duckdbImage}, args[len(args)-3:])
+
+	c := newContainer(cliRuntime{bin: "docker"}, nil, duckdbImage, db.exe, "")
+	assert.Equal(t, []string{"/opt/duckdb/duckdb"}, c.execArgs)
+	c = newContainer(cliRuntime{bin: "docker"}, nil, duckdbImage, "", "/data/foo.db")
+	assert.Equal(t, []string{"duckdb", "/data/foo.db"}, c.execArgs)
+}
+
+func TestDockerArgsCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	db := NewInMemoryDB(Opts{Docker: true, CacheDir: dir})
+	defer db.Close()
+
+	args := db.dockerArgs("test")
+	assert.Contains(t, args, fmt.Sprintf("%s:%s", dir, dir))
+}
+
+func TestQueryDocker(t *testing.T) {
+	db := NewDuckDB("foo", Opts{Docker: true})
+
+	commands := []string{
+		"CREATE TABLE t1 (i INTEGER, j INTEGER);",
+		"INSERT INTO t1 VALUES (1, 5);",
+	}
+	_, err := db.RunCommands(commands)
+	assert.Nil(t, err)
+
+	// the database file is kept on the host
+	_, err = os.Stat("foo")
+	assert.Nil(t, err)
+
+	res, err := db.Query("SELECT * from t1;")
+	assert.Nil(t, err)
+	assert.Contains(t, res, `[{"i":1,"j":5}]`)
+
+	err = db.Destroy()
+	assert.Nil(t, err)
+}
+
+func TestQueryFrameIntoFrameQueryError(t *testing.T) {
+	for _, cacheDuration := range []int{0, 5} {
+		db := NewInMemoryDB(Opts{CacheDuration: cacheDuration})
+
+		frame := data.NewFrame("foo", data.NewField("value", nil, []string{"test"}))
+		frame.RefID = "foo"
+		frames := []*data.Frame{frame}
+
+		model, err := db.QueryFramesToFrames("foo", "select missing from foo", frames)
+		assert.Nil(t, model)
+
+		var qerr *QueryError
+		assert.True(t, errors.As(err, &qerr))
+		assert.Equal(t, KindQuery, qerr.Kind)
+		assert.Equal(t, "Binder Error", qerr.Class)
+	}
+}
@@ -0,0 +1,232 @@
+package duck
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrorKind is the reason a call to duckdb failed
+type ErrorKind string
+
+const (
+	// KindQuery is an error reported by duckdb for the sql
+	KindQuery ErrorKind = "query"
+	// KindValidation is sql that is not allowed by the validation of frame queries
+	KindValidation ErrorKind = "validation"
+	// KindExecutable is a duckdb process or container that could not be started, or a
+	// container runtime that failed to run duckdb
+	KindExecutable ErrorKind = "executable"
+	// KindConversion is a frame or result that could not be converted
+	KindConversion ErrorKind = "conversion"
+	// KindTimeout is a call that ran longer than its timeout or deadline
+	KindTimeout ErrorKind = "timeout"
+	// KindCanceled is a call whose context was canceled
+	KindCanceled ErrorKind = "canceled"
+	// KindProcess is a duckdb process that exited or stopped responding
+	KindProcess ErrorKind = "process"
+)
+
+// QueryError is returned for every failed call to duckdb. Use errors.As to inspect it.
+type QueryError struct {
+	Kind ErrorKind
+	// Class is the duckdb error class, such as "Parser Error" or "Catalog Error"
+	Class   string
+	Message string
+	// Line and Position locate the error in the statement when duckdb reports them, starting at 1
+	Line     int
+	Position int
+	// Statement is the index of the command that failed, or -1 when it is not known.
+	// Frame queries report 0 for the query, and -1 for the commands that load the frames.
+	Statement int
+	Err       error
+}
+
+func newError(kind ErrorKind, message string, err error) *QueryError {
+	return &QueryError{Kind: kind, Message: message, Statement: -1, Err: err}
+}
+
+func (e *QueryError) Error() string {
+	msg := e.Message
+	if e.Class != "" {
+		msg = e.Class + ": " + msg
+	}
+	if msg == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return msg
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// conversionError marks errors converting frames and results
+func conversionError(err error) error {
+	var qerr *QueryError
+	if errors.As(err, &qerr) {
+		return err
+	}
+	return newError(KindConversion, err.Error(), err)
+}
+
+// commandError classifies the error of a duckdb process that was run
+func commandError(err error, stderr string, lines []int) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return newError(KindExecutable, "failed to run duckdb: "+err.Error(), err)
+	}
+	if strings.TrimSpace(stderr) == "" {
+		return newError(KindProcess, "duckdb exited: "+err.Error(), err)
+	}
+	qerr := parseError(stderr, lines)
+	qerr.Err = err
+	return qerr
+}
+
+// runtimeExitCodes are the exit codes of docker and podman when they could not run the container
+// or the command in it, rather than the exit code of duckdb
+var runtimeExitCodes = map[int]bool{125: true, 126: true, 127: true}
+
+// containerError returns an executable error when the container runtime failed instead of duckdb:
+// it exited with one of its own exit codes, or printed an error that did not come from duckdb.
+// It returns nil for the errors of duckdb.
+func containerError(err error, stderr string) *QueryError {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return nil
+	}
+	if !runtimeExitCodes[exitErr.ExitCode()] && duckdbError(stderr) {
+		return nil
+	}
+	return newError(KindExecutable, "failed to run container: "+strings.TrimSpace(stderr), err)
+}
+
+// duckdbError returns true if duckdb printed an error to stderr
+func duckdbError(stderr string) bool {
+	for _, line := range strings.Split(stderr, "\n") {
+		if errorLine.MatchString(line) || errorClass.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionError classifies the error of a pooled session
+func sessionError(err error) error {
+	var qerr *QueryError
+	switch {
+	case errors.As(err, &qerr):
+		return err
+	case errors.Is(err, errSessionTimeout):
+		return newError(KindTimeout, "", fmt.Errorf("%w: %w", ErrTimeout, err))
+	}
+	return newError(KindProcess, "", err)
+}
+
+var (
+	errorLine  = regexp.MustCompile(`^Error: (?:near line (\d+): )?(.*)$`)
+	errorClass = regexp.MustCompile(`^((?:[A-Za-z]+ )+Error): `)
+	sqlLine    = regexp.MustCompile(`^LINE (\d+): `)
+)
+
+// parseError reads the first error duckdb printed to stderr. The cli prints
+// the input line a failed statement started on, so lines (the input line each
+// command starts on) is used to find the command.
+func parseError(stderr string, lines []int) *QueryError {
+	qerr := newError(KindQuery, strings.TrimSpace(stderr), nil)
+
+	text := strings.Split(strings.TrimRight(stderr, "\n"), "\n")
+	start := -1
+	for i, line := range text {
+		if errorLine.MatchString(line) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return qerr
+	}
+
+	m := errorLine.FindStringSubmatch(text[start])
+	if m[1] != "" {
+		line, _ := strconv.Atoi(m[1])
+		for i, l := range lines {
+			if l <= line {
+				qerr.Statement = i
+			}
+		}
+	}
+
+	message := []string{m[2]}
+	for i := start + 1; i < len(text); i++ {
+		line := text[i]
+		if errorLine.MatchString(line) {
+			break
+		}
+		if loc := sqlLine.FindStringSubmatchIndex(line); loc != nil {
+			qerr.Line, _ = strconv.Atoi(line[loc[2]:loc[3]])
+			// the next line has a caret under the position of the error
+			if i+1 < len(text) {
+				if caret := strings.Index(text[i+1], "^"); caret >= loc[1] {
+					qerr.Position = caret - loc[1] + 1
+					i++
+				}
+			}
+			continue
+		}
+		message = append(message, line)
+	}
+
+	msg := strings.TrimSpace(strings.Join(message, "\n"))
+	if c := errorClass.FindStringSubmatch(msg); c != nil {
+		qerr.Class = c[1]
+		msg = strings.TrimSpace(msg[len(c[1])+2:])
+	}
+	qerr.Message = msg
+	return qerr
+}
+
+// parseErrors reads every error duckdb printed to stderr. The cli keeps running the
+// statements after a failed one, so there is an error for each failed statement.
+func parseErrors(stderr string, lines []int) []*QueryError {
+	text := strings.Split(strings.TrimRight(stderr, "\n"), "\n")
+	errs := []*QueryError{}
+	start := -1
+	for i, line := range text {
+		if !errorLine.MatchString(line) {
+			continue
+		}
+		if start >= 0 {
+			errs = append(errs, parseError(strings.Join(text[start:i], "\n"), lines))
+		}
+		start = i
+	}
+	if start >= 0 {
+		errs = append(errs, parseError(strings.Join(text[start:], "\n"), lines))
+	} else if strings.TrimSpace(stderr) != "" {
+		errs = append(errs, parseError(stderr, lines))
+	}
+	return errs
+}
+
+// commandLines returns the input line each command starts on, when the commands
+// are written by script starting at the first line
+func commandLines(commands []string, first int) []int {
+	lines := make([]int, len(commands))
+	// the first line is the .mode command
+	line := first + 1
+	for i, c := range commands {
+		lines[i] = line
+		line += strings.Count(c, newline) + 1
+	}
+	return lines
+}
+
+// validationError is returned for sql that is not allowed
+func validationError(format string, args ...any) error {
+	return newError(KindValidation, fmt.Sprintf(format, args...), nil)
+}
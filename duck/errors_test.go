@@ -0,0 +1,107 @@
+package duck
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseError(t *testing.T) {
+	stderr := "Error: near line 3: Catalog Error: Table with name missing does not exist!\nDid you mean \"t1\"?\n"
+	qerr := parseError(stderr, []int{2, 3, 4})
+
+	assert.Equal(t, KindQuery, qerr.Kind)
+	assert.Equal(t, "Catalog Error", qerr.Class)
+	assert.Equal(t, "Table with name missing does not exist!\nDid you mean \"t1\"?", qerr.Message)
+	assert.Equal(t, 1, qerr.Statement)
+	assert.Equal(t, 0, qerr.Line)
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	stderr := "Error: near line 2: Parser Error: syntax error at or near \"FRM\"\n" +
+		"LINE 1: SELECT * FRM t1;\n" +
+		"                 ^\n" +
+		"Error: near line 3: Catalog Error: not reported\n"
+	qerr := parseError(stderr, []int{2, 3})
+
+	assert.Equal(t, "Parser Error", qerr.Class)
+	assert.Equal(t, `syntax error at or near "FRM"`, qerr.Message)
+	assert.Equal(t, 0, qerr.Statement)
+	assert.Equal(t, 1, qerr.Line)
+	assert.Equal(t, 10, qerr.Position)
+	assert.Equal(t, `Parser Error: syntax error at or near "FRM"`, qerr.Error())
+}
+
+func TestParseErrorMultilineCommand(t *testing.T) {
+	commands := []string{"CREATE TABLE t1 (i INTEGER);", "SELECT *\nFROM t1\nWHERE x = 1;", "SELECT 1;"}
+	lines := commandLines(commands, 1)
+	assert.Equal(t, []int{2, 3, 6}, lines)
+
+	qerr := parseError("Error: near line 3: Binder Error: Referenced column \"x\" not found", lines)
+	assert.Equal(t, 1, qerr.Statement)
+	assert.Equal(t, "Binder Error", qerr.Class)
+}
+
+func TestParseErrorWithoutClass(t *testing.T) {
+	qerr := parseError("Error: unknown mode \"foo\"\n", nil)
+	assert.Equal(t, "", qerr.Class)
+	assert.Equal(t, `unknown mode "foo"`, qerr.Message)
+	assert.Equal(t, -1, qerr.Statement)
+
+	qerr = parseError("something unexpected\n", nil)
+	assert.Equal(t, "something unexpected", qerr.Message)
+}
+
+func TestAstError(t *testing.T) {
+	ast := map[string]any{
+		"error":         true,
+		"error_type":    "parser",
+		"error_message": "syntax error at or near \"SELEC\"",
+		"position":      "0",
+	}
+	err := astError(ast, "")
+
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindQuery, qerr.Kind)
+	assert.Equal(t, "Parser Error", qerr.Class)
+	assert.Equal(t, 1, qerr.Position)
+}
+
+func TestExecutableError(t *testing.T) {
+	db := NewInMemoryDB(Opts{Exe: "/missing/duckdb"})
+
+	_, err := db.Query("SELECT 1;")
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindExecutable, qerr.Kind)
+}
+
+func TestContextErrorKind(t *testing.T) {
+	var qerr *QueryError
+	err := contextError(context.DeadlineExceeded)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindTimeout, qerr.Kind)
+
+	err = contextError(context.Canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindCanceled, qerr.Kind)
+}
+
+func TestSessionErrorKind(t *testing.T) {
+	var qerr *QueryError
+	err := sessionError(errSessionTimeout)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, errSessionTimeout)
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindTimeout, qerr.Kind)
+
+	err = sessionError(errors.New("broken pipe"))
+	assert.NotErrorIs(t, err, ErrTimeout)
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindProcess, qerr.Kind)
+}
@@ -0,0 +1,65 @@
+//go:build unix
+
+package duck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// astOutput is the validation of a query with a valid sql ast
+const astOutput = `[{"ast":{"error":false,"statements":[{"node":{"type":"SELECT_NODE"}}]}}]` + "\n"
+
+// astScript answers the validation of a query with astOutput
+var astScript = "case \"$input\" in *json_serialize_sql*)\n" + printScript(astOutput, "", 0) + "esac\n"
+
+// fakeDuckDB returns a duckdb executable that runs the shell script. The input of the last run
+// is in "$input" and is returned by fakeInput.
+func fakeDuckDB(t *testing.T, script string) string {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "duckdb")
+	input := shellQuote(filepath.Join(dir, "input"))
+	head := fmt.Sprintf("#!/bin/sh\ncat > %s\ninput=$(cat %s)\n", input, input)
+	assert.Nil(t, os.WriteFile(exe, []byte(head+script), 0700))
+	return exe
+}
+
+// fakeSessionDuckDB returns a duckdb executable that keeps reading commands like a session.
+// It prints what .print commands print and answers the sentinels of each batch. The input is
+// returned by fakeInput.
+func fakeSessionDuckDB(t *testing.T) string {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "duckdb")
+	script := fmt.Sprintf(`#!/bin/sh
+while IFS= read -r line; do
+	printf '%%s\n' "$line" >> %s
+	case "$line" in
+	".print "*) text=${line#.print }; printf '%%s\n' "${text%% }" ;;
+	"SELECT error("*) printf '%%s\n' "$line" >&2 ;;
+	esac
+done
+`, shellQuote(filepath.Join(dir, "input")))
+	assert.Nil(t, os.WriteFile(exe, []byte(script), 0700))
+	return exe
+}
+
+// fakeInput returns the input of the last run of a fake duckdb
+func fakeInput(t *testing.T, exe string) string {
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(exe), "input"))
+	assert.Nil(t, err)
+	return string(b)
+}
+
+// printScript returns a script that prints stdout and stderr, and exits with code
+func printScript(stdout string, stderr string, code int) string {
+	return fmt.Sprintf("printf '%%s' %s\nprintf '%%s' %s >&2\nexit %d\n", shellQuote(stdout), shellQuote(stderr), code)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
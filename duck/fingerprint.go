@@ -0,0 +1,53 @@
+package duck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Fingerprint identifies the frames in the cache key, so new data is not answered from the cache
+type Fingerprint func(frames []*sdk.Frame) (string, error)
+
+// ContentFingerprint hashes the schema and values of the frames. It is the default fingerprint.
+func ContentFingerprint(frames []*sdk.Frame) (string, error) {
+	h := sha256.New()
+	for _, frame := range frames {
+		writeSchema(h, frame)
+		// only the fields are hashed, notices added to the frame meta don't change the data
+		b, err := sdk.NewFrame("", frame.Fields...).MarshalArrow()
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VersionFingerprint hashes the schema of the frames and the version returned by the caller.
+// Use it for frames that are too large to hash, with a version that changes with the data,
+// such as the time range of the query.
+func VersionFingerprint(version func(frames []*sdk.Frame) string) Fingerprint {
+	return func(frames []*sdk.Frame) (string, error) {
+		h := sha256.New()
+		for _, frame := range frames {
+			writeSchema(h, frame)
+		}
+		h.Write([]byte(version(frames)))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
+func writeSchema(h hash.Hash, frame *sdk.Frame) {
+	fmt.Fprintf(h, "frame %q %q %d\n", frame.RefID, frame.Name, len(frame.Fields))
+	for _, field := range frame.Fields {
+		displayName := ""
+		if field.Config != nil {
+			displayName = field.Config.DisplayName
+		}
+		fmt.Fprintf(h, "field %q %q %s %q\n", field.Name, displayName, field.Type(), field.Labels.String())
+	}
+}
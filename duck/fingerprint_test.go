@@ -0,0 +1,80 @@
+package duck
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func fingerprintFrame(values []int64, labels data.Labels) *data.Frame {
+	frame := data.NewFrame("foo", data.NewField("value", labels, values))
+	frame.RefID = "A"
+	return frame
+}
+
+func TestContentFingerprint(t *testing.T) {
+	fp, err := ContentFingerprint([]*data.Frame{fingerprintFrame([]int64{1, 2}, nil)})
+	assert.Nil(t, err)
+
+	same, err := ContentFingerprint([]*data.Frame{fingerprintFrame([]int64{1, 2}, nil)})
+	assert.Nil(t, err)
+	assert.Equal(t, fp, same)
+
+	values, err := ContentFingerprint([]*data.Frame{fingerprintFrame([]int64{1, 3}, nil)})
+	assert.Nil(t, err)
+	assert.NotEqual(t, fp, values)
+
+	labels, err := ContentFingerprint([]*data.Frame{fingerprintFrame([]int64{1, 2}, data.Labels{"host": "a"})})
+	assert.Nil(t, err)
+	assert.NotEqual(t, fp, labels)
+
+	// notices added to the meta are not part of the data
+	frame := fingerprintFrame([]int64{1, 2}, nil)
+	frame.AppendNotices(data.Notice{Text: "Data retrieved from cache"})
+	notices, err := ContentFingerprint([]*data.Frame{frame})
+	assert.Nil(t, err)
+	assert.Equal(t, fp, notices)
+}
+
+func TestVersionFingerprint(t *testing.T) {
+	version := "1"
+	fingerprint := VersionFingerprint(func(frames []*data.Frame) string {
+		return version
+	})
+
+	fp, err := fingerprint([]*data.Frame{fingerprintFrame([]int64{1, 2}, nil)})
+	assert.Nil(t, err)
+
+	// values are not hashed
+	values, err := fingerprint([]*data.Frame{fingerprintFrame([]int64{1, 3}, nil)})
+	assert.Nil(t, err)
+	assert.Equal(t, fp, values)
+
+	// the schema is
+	labels, err := fingerprint([]*data.Frame{fingerprintFrame([]int64{1, 2}, data.Labels{"host": "a"})})
+	assert.Nil(t, err)
+	assert.NotEqual(t, fp, labels)
+
+	version = "2"
+	newVersion, err := fingerprint([]*data.Frame{fingerprintFrame([]int64{1, 2}, nil)})
+	assert.Nil(t, err)
+	assert.NotEqual(t, fp, newVersion)
+}
+
+func TestCacheKey(t *testing.T) {
+	db := NewInMemoryDB(Opts{CacheDuration: 10})
+	fd := FrameData{cache: db.cache, db: db}
+
+	key, err := fd.key("foo", "select * from A", []*data.Frame{fingerprintFrame([]int64{1, 2}, nil)})
+	assert.Nil(t, err)
+	refreshed, err := fd.key("foo", "select * from A", []*data.Frame{fingerprintFrame([]int64{1, 3}, nil)})
+	assert.Nil(t, err)
+	assert.NotEqual(t, key, refreshed)
+
+	// frames are not hashed when there is no cache
+	fd.cache = nil
+	key, err = fd.key("foo", "select * from A", []*data.Frame{fingerprintFrame([]int64{1, 2}, nil)})
+	assert.Nil(t, err)
+	assert.Equal(t, CacheKey{Name: "foo", Query: "select * from A"}, key)
+}
@@ -0,0 +1,70 @@
+package duck
+
+import (
+	"context"
+	"sync"
+)
+
+// flight runs one call at a time for each key, and shares its result with the callers
+// that ask for the same key while it runs. The call keeps running while any caller is
+// waiting for it, and is canceled when the contexts of all of them are done.
+type flight struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done    chan struct{}
+	val     any
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do returns the result of fn for the key, and true when the call was started by another caller
+func (g *flight) do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, bool, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	c, shared := g.calls[key]
+	if !shared {
+		// the call outlives a caller that gives up, so it only keeps the values of the context
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			c.val, c.err = fn(callCtx)
+			g.forget(key, c)
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, shared, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody is waiting, a new caller starts a new call
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, shared, contextError(ctx.Err())
+	}
+}
+
+func (g *flight) forget(key string, c *flightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,109 @@
+package duck
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForCallers waits until n callers are waiting for the key
+func waitForCallers(t *testing.T, g *flight, key string, n int) {
+	assert.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c, ok := g.calls[key]
+		return ok && c.waiters == n
+	}, 2*time.Second, time.Millisecond)
+}
+
+func TestFlightShared(t *testing.T) {
+	var g flight
+	var calls int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _, err := g.do(context.Background(), "key", func(ctx context.Context) (any, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "result", nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, "result", v)
+		}()
+	}
+	waitForCallers(t, &g, "key", 5)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+
+	// the next call runs again
+	v, shared, err := g.do(context.Background(), "key", func(ctx context.Context) (any, error) {
+		return "again", nil
+	})
+	assert.Nil(t, err)
+	assert.False(t, shared)
+	assert.Equal(t, "again", v)
+}
+
+func TestFlightCanceled(t *testing.T) {
+	var g flight
+	release := make(chan struct{})
+	callCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (any, error) {
+		callCtx <- ctx
+		<-release
+		return "result", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, _, err := g.do(ctx, "key", fn)
+		canceled <- err
+	}()
+	waitForCallers(t, &g, "key", 1)
+
+	result := make(chan any)
+	go func() {
+		v, shared, err := g.do(context.Background(), "key", fn)
+		assert.Nil(t, err)
+		assert.True(t, shared)
+		result <- v
+	}()
+	waitForCallers(t, &g, "key", 2)
+
+	// the first caller gives up, the call keeps running for the second
+	cancel()
+	assert.ErrorIs(t, <-canceled, context.Canceled)
+	shared := <-callCtx
+	assert.Nil(t, shared.Err())
+
+	close(release)
+	assert.Equal(t, "result", <-result)
+}
+
+func TestFlightAllCanceled(t *testing.T) {
+	var g flight
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := g.do(ctx, "key", func(ctx context.Context) (any, error) {
+			<-ctx.Done()
+			done <- ctx.Err()
+			return nil, ctx.Err()
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+	waitForCallers(t, &g, "key", 1)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
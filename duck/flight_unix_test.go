@@ -0,0 +1,86 @@
+//go:build unix
+
+package duck
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/scottlepp/go-duck/duck/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockConversions makes frame conversions wait until release is closed, and counts them
+func blockConversions(t *testing.T, release chan struct{}) *int32 {
+	var conversions int32
+	toFiles = func(frames []*sdk.Frame, format string, opts data.ParquetOpts) (map[string]string, error) {
+		atomic.AddInt32(&conversions, 1)
+		<-release
+		return data.ToFormat(frames, format, opts)
+	}
+	t.Cleanup(func() { toFiles = data.ToFormat })
+	return &conversions
+}
+
+func TestQueryFramesSingleFlight(t *testing.T) {
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, printScript(astOutput, "", 0)), CacheDuration: 10})
+	defer db.Close()
+	release := make(chan struct{})
+	conversions := blockConversions(t, release)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// every caller has its own frames with the same data
+			frame := sdk.NewFrame("foo", sdk.NewField("value", nil, []string{"test"}))
+			frame.RefID = "foo"
+			res, _, err := db.QueryFrames("foo", "select * from foo", []*sdk.Frame{frame})
+			assert.Nil(t, err)
+			assert.Contains(t, res, "SELECT_NODE")
+		}()
+	}
+
+	fingerprint, err := ContentFingerprint(testFrames())
+	assert.Nil(t, err)
+	waitForCallers(t, &db.flight, "query:0:"+fingerprint+":foo:select * from foo", 10)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), *conversions)
+}
+
+func TestQueryFramesSingleFlightNoCache(t *testing.T) {
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, printScript(astOutput, "", 0))})
+	release := make(chan struct{})
+	conversions := blockConversions(t, release)
+
+	var fingerprints int32
+	db.fingerprint = func(frames []*sdk.Frame) (string, error) {
+		atomic.AddInt32(&fingerprints, 1)
+		return ContentFingerprint(frames)
+	}
+
+	// without a cache the frames aren't hashed, callers with the same frames share the call
+	frames := testFrames()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, _, err := db.QueryFrames("foo", "select * from foo", frames)
+			assert.Nil(t, err)
+			assert.Contains(t, res, "SELECT_NODE")
+		}()
+	}
+
+	waitForCallers(t, &db.flight, "query:0:"+flightFrames("", frames)+":foo:select * from foo", 10)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), *conversions)
+	assert.Equal(t, int32(0), fingerprints)
+}
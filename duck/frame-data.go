@@ -1,66 +1,242 @@
 package duck
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path"
+	"strings"
 	"sync"
-	"time"
 
 	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/scottlepp/go-duck/duck/data"
 )
 
 type FrameData struct {
-	cacheDuration int
-	cache         *cache
-	db            *DuckDB
+	cache  Cache
+	db     *DuckDB
+	output queryOutput
+	// fingerprint of the frames, when it was already computed
+	fingerprint string
 }
 
-func (f *FrameData) Query(name string, query string, frames []*sdk.Frame) (string, bool, error) {
-	dirs, cached, err := f.data(name, query, frames)
+// toFiles converts frames to files of the format, replaced in tests to count conversions
+var toFiles = data.ToFormat
+
+// queryOutput is what a frame query returns
+type queryOutput int
+
+const (
+	// outputRows returns the rows printed in the mode of the database, see ParseOutput
+	outputRows queryOutput = iota
+	// outputTyped returns the DESCRIBE result of the query, followed by the rows as json
+	outputTyped
+	// outputParquet writes the rows to a parquet file and returns its path
+	outputParquet
+)
+
+func (f *FrameData) Query(ctx context.Context, name string, query string, frames []*sdk.Frame) (string, bool, error) {
+	if f.db.stream {
+		// the frames are streamed to duckdb, there are no files to cache
+		res, err := f.query(ctx, query, nil, frames)
+		if err != nil {
+			logger.Error("error running commands", "name", name, "error", err)
+		}
+		return res, false, err
+	}
+	key, err := f.key(name, query, frames)
+	if err != nil {
+		logger.Error("error creating cache key", "name", name, "error", err)
+		return "", false, conversionError(fmt.Errorf("failed to fingerprint frames: %w", err))
+	}
+	dirs, release, cached, err := f.data(key, frames)
+	if err != nil {
+		logger.Error("error converting to parquet", "name", name, "error", err)
+		return "", false, conversionError(err)
+	}
+	if cached {
+		// the cache keeps the files until the query is done with them
+		defer release()
+	}
+
+	res, err := f.query(ctx, query, dirs, frames)
 	if err != nil {
-		logger.Error("error converting to parquet", "error", err)
+		logger.Error("error running commands", "name", name, "cached", cached, "error", err)
+		if cached {
+			// the cached files may be the cause, so the frames are converted again by the next query
+			f.cache.Remove(key)
+		} else {
+			go wipe(dirs)
+		}
 		return "", cached, err
 	}
 
-	defer f.postProcess(name, query, dirs, cached)
+	if !cached {
+		// new parquet files are only kept when they are added to the cache
+		if f.cache != nil {
+			f.cache.Set(key, dirs)
+		} else {
+			go wipe(dirs)
+		}
+	}
 
-	// create a wait group to wait for the query to finish
-	// if the cache duration is exceeded, wait before deleting the cache ( parquet files )
-	var wg sync.WaitGroup
-	wg.Add(1)
-	f.cache.setWait(fmt.Sprintf("%s:%s", name, query), &wg)
+	return res, cached, nil
+}
 
-	var res string
-	var qerr error
+// key returns the cache key of the query. When caching, it includes the fingerprint
+// of the frames so a query on new data doesn't return the cached results.
+func (f *FrameData) key(name string, query string, frames []*sdk.Frame) (CacheKey, error) {
+	key := CacheKey{Name: name, Query: query}
+	if f.cache == nil || f.db.fingerprint == nil {
+		return key, nil
+	}
+	if f.fingerprint != "" {
+		key.Fingerprint = f.fingerprint
+		return key, nil
+	}
+	fingerprint, err := f.db.fingerprint(frames)
+	if err != nil {
+		return key, err
+	}
+	key.Fingerprint = fingerprint
+	return key, nil
+}
 
-	go func() {
-		res, qerr = f.runQuery(query, dirs, frames)
-		wg.Done()
-	}()
+// query runs the query against the parquet files
+func (f *FrameData) query(ctx context.Context, query string, dirs Dirs, frames []*sdk.Frame) (string, error) {
+	// the conversion may have outlived the request
+	if err := ctx.Err(); err != nil {
+		return "", contextError(err)
+	}
+	return f.runQuery(ctx, query, dirs, frames)
+}
 
-	wg.Wait()
-	f.cache.deleteWait(fmt.Sprintf("%s:%s", name, query))
+func (f *FrameData) runQuery(ctx context.Context, query string, dirs Dirs, frames []*sdk.Frame) (string, error) {
+	switch f.output {
+	case outputTyped:
+		// the results are decoded from json, whatever the mode of the database
+		return f.run(ctx, dirs, frames, ".mode "+ModeJSON, describe(query), query)
+	case outputParquet:
+		return f.runParquet(ctx, query, dirs, frames)
+	default:
+		return f.run(ctx, dirs, frames, query)
+	}
+}
 
-	if qerr != nil {
-		logger.Error("error running commands", "error", err)
-		return "", cached, err
+// run creates the views for the frames and runs the commands against them
+func (f *FrameData) run(ctx context.Context, dirs Dirs, frames []*sdk.Frame, commands ...string) (string, error) {
+	if f.db.stream {
+		return f.stream(ctx, frames, commands...)
+	}
+	if err := f.db.installExtensions(ctx); err != nil {
+		return "", err
 	}
+	cmds := loadExtensions(f.db.format, f.db.docker)
+	cmds = append(cmds, createViews(frames, dirs, f.db.format)...)
+	first := len(cmds)
+	cmds = append(cmds, commands...)
+	if f.db.pool != nil {
+		// sessions are reused, so don't leave views pointing at parquet files that will be removed
+		cmds = append(cmds, dropViews(frames)...)
+	}
+	res, err := f.db.RunCommandsContext(ctx, cmds)
+	return res, queryStatement(err, first, len(commands))
+}
 
-	key := fmt.Sprintf("%s:%s", name, query)
-	if f.cacheDuration > 0 && !cached {
-		f.cache.set(key, dirs)
+// queryStatement reports an error in the count commands run for the query, from first, as
+// statement 0: the query of the caller. The commands that load the frames and extensions
+// are not the caller's, so their errors are not reported as a statement.
+func queryStatement(err error, first int, count int) error {
+	var qerr *QueryError
+	if errors.As(err, &qerr) && qerr.Statement >= 0 {
+		if qerr.Statement >= first && qerr.Statement < first+count {
+			qerr.Statement = 0
+		} else {
+			qerr.Statement = -1
+		}
 	}
+	return err
+}
 
-	return res, cached, nil
+// runParquet copies the results of the query to a parquet file and returns the path of the file.
+// The types of the result are read first, so columns can be cast to types the frame supports.
+func (f *FrameData) runParquet(ctx context.Context, query string, dirs Dirs, frames []*sdk.Frame) (string, error) {
+	res, err := f.run(ctx, dirs, frames, ".mode "+ModeJSON, describe(query))
+	if err != nil {
+		return "", err
+	}
+	columns, _, err := decodeResults(res)
+	if err != nil {
+		return "", conversionError(err)
+	}
+
+	dir, err := os.MkdirTemp("", data.TempDirPrefix)
+	if err != nil {
+		logger.Error("failed to create temp dir", "error", err)
+		return "", conversionError(err)
+	}
+	file := path.Join(dir, "results.parquet")
+	_, err = f.run(ctx, dirs, frames, copyToParquet(query, columns, file))
+	if err != nil {
+		wipe(Dirs{"results": dir})
+		return "", err
+	}
+	return file, nil
+}
+
+// describe returns the DESCRIBE command for a query, which lists the result columns and their types
+func describe(query string) string {
+	return fmt.Sprintf("DESCRIBE %s;", closeQuery(query))
+}
+
+// copyToParquet returns a COPY command that writes the results of the query to a parquet file
+func copyToParquet(query string, columns []data.Column, file string) string {
+	selects := make([]string, len(columns))
+	for i, col := range columns {
+		selects[i] = fmt.Sprintf("%s AS %s", parquetColumn(col), quoteIdentifier(col.Name))
+	}
+	file = strings.ReplaceAll(file, "'", "''")
+	return fmt.Sprintf("COPY (SELECT %s FROM (%s)) TO '%s' (FORMAT PARQUET);", strings.Join(selects, ", "), closeQuery(query), file)
+}
+
+// parquetColumn casts a column to a type that is read back as a type supported by frames:
+// timestamps are stored as nanoseconds in UTC, nested types as json bytes, and
+// types that have no field type as doubles or strings.
+func parquetColumn(col data.Column) string {
+	name := quoteIdentifier(col.Name)
+	duckType := strings.ToUpper(col.Type)
+	switch data.FieldType(col.Type) {
+	case sdk.FieldTypeNullableJSON:
+		return fmt.Sprintf("encode(CAST(to_json(%s) AS VARCHAR))", name)
+	case sdk.FieldTypeNullableTime:
+		if duckType == "TIMESTAMP WITH TIME ZONE" || duckType == "TIMESTAMPTZ" {
+			return fmt.Sprintf("CAST(timezone('UTC', %s) AS TIMESTAMP_NS)", name)
+		}
+		return fmt.Sprintf("CAST(%s AS TIMESTAMP_NS)", name)
+	case sdk.FieldTypeNullableFloat64:
+		return fmt.Sprintf("CAST(%s AS DOUBLE)", name)
+	case sdk.FieldTypeNullableString:
+		return fmt.Sprintf("CAST(%s AS VARCHAR)", name)
+	}
+	return name
+}
+
+func trimQuery(query string) string {
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(query), ";"))
+}
+
+// closeQuery returns the query without its semicolon for use inside another command. It ends in
+// a new line, so what closes the command is not part of a comment at the end of the query.
+func closeQuery(query string) string {
+	return trimQuery(query) + newline
 }
 
-func (f *FrameData) runQuery(query string, dirs Dirs, frames []*sdk.Frame) (string, error) {
-	commands := createViews(frames, dirs)
-	commands = append(commands, query)
-	return f.db.RunCommands(commands)
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
 }
 
-func createViews(frames []*sdk.Frame, dirs Dirs) []string {
+func createViews(frames []*sdk.Frame, dirs Dirs, format string) []string {
 	commands := []string{}
 	created := map[string]bool{}
 	logger.Debug("starting to create views from frames", "frames", len(frames))
@@ -68,7 +244,7 @@ func createViews(frames []*sdk.Frame, dirs Dirs) []string {
 		if created[frame.RefID] {
 			continue
 		}
-		cmd := fmt.Sprintf("CREATE VIEW %s AS (SELECT * from '%s/*.parquet');", frame.RefID, dirs[frame.RefID])
+		cmd := fmt.Sprintf("CREATE OR REPLACE VIEW %s AS (SELECT * from %s);", frame.RefID, reader(format, dirs[frame.RefID]))
 		logger.Debug("creating view", "cmd", cmd)
 		commands = append(commands, cmd)
 		created[frame.RefID] = true
@@ -76,76 +252,107 @@ func createViews(frames []*sdk.Frame, dirs Dirs) []string {
 	return commands
 }
 
-func (f *FrameData) data(name string, query string, frames []*sdk.Frame) (Dirs, bool, error) {
-	if f.cacheDuration > 0 {
-		// check the cache
-		key := fmt.Sprintf("%s:%s", name, query)
-		if d, ok := f.cache.get(key); ok {
-			return d, true, nil
-		}
-	}
+// extensionDir keeps the extensions installed by containers, which are removed after each call.
+// It is in the temp dir, which is mounted in the containers.
+var extensionDir = path.Join(tempDir, "go-duck-extensions")
 
-	dirs, err := data.ToParquet(frames, f.db.chunk)
-	return dirs, false, err
+// extensions records whether the extensions of the format are installed
+type extensions struct {
+	mu        sync.Mutex
+	installed bool
 }
 
-func (f *FrameData) postProcess(name string, query string, dirs Dirs, cached bool) {
-	go func() {
-		if f.cacheDuration == 0 {
-			wipe(dirs)
-			return
-		}
-		// if result is not cached, a new cache entry will be created
-		// delete the new cache entry after cacheDuration
-		if !cached {
-			time.Sleep(time.Duration(f.cacheDuration) * time.Second)
-			key := fmt.Sprintf("%s:%s", name, query)
-			f.cache.delete(key)
-			// if the query is running wait for the query to finish before deleting the parquet files
-			wg, wait := f.cache.getWait(key)
-			if wait {
-				wg.Wait()
-				wipe(dirs)
-				return
-			}
-			wipe(dirs)
-		}
-	}()
-}
-
-type cache struct {
-	store sync.Map
-	wait  sync.Map
+// installExtensions installs the extensions the format is read with, once per database.
+// Installing downloads the extension, so queries only load it and don't need network access.
+func (d *DuckDB) installExtensions(ctx context.Context) error {
+	if data.Extension(d.format) != "arrow" {
+		return nil
+	}
+	d.extensions.mu.Lock()
+	defer d.extensions.mu.Unlock()
+	if d.extensions.installed {
+		return nil
+	}
+	// duckdb reads arrow files with the nanoarrow community extension
+	commands := append(extensionDirectory(d.docker), "INSTALL nanoarrow FROM community;")
+	if _, err := d.RunCommandsContext(ctx, commands); err != nil {
+		logger.Error("failed to install extensions", "format", d.format, "error", err)
+		return queryStatement(err, 0, 0)
+	}
+	d.extensions.installed = true
+	return nil
 }
 
-func (c *cache) set(key string, value Dirs) {
-	c.store.Store(key, value)
+// loadExtensions returns the commands that load the extensions the format is read with
+func loadExtensions(format string, docker bool) []string {
+	if data.Extension(format) != "arrow" {
+		return nil
+	}
+	return append(extensionDirectory(docker), "LOAD nanoarrow;")
 }
 
-func (c *cache) get(key string) (Dirs, bool) {
-	val, ok := c.store.Load(key)
-	if !ok {
-		return nil, false
+// extensionDirectory returns the command that sets the directory of the extensions
+func extensionDirectory(docker bool) []string {
+	if !docker {
+		return []string{}
 	}
-	return val.(Dirs), true
+	return []string{fmt.Sprintf("SET extension_directory = '%s';", strings.ReplaceAll(extensionDir, "'", "''"))}
 }
 
-func (c *cache) delete(key string) {
-	c.store.Delete(key)
+// reader returns the duckdb table function that reads the files of the format in the directory.
+// Frames of a RefID may have different columns, so the rows are matched by column name.
+func reader(format string, dir string) string {
+	ext := data.Extension(format)
+	files := strings.ReplaceAll(fmt.Sprintf("%s/*.%s", dir, ext), "'", "''")
+	switch ext {
+	case "arrow":
+		return fmt.Sprintf("read_arrow('%s')", files)
+	case "csv":
+		return fmt.Sprintf("read_csv('%s', header = true, auto_detect = true, union_by_name = true)", files)
+	case "ndjson":
+		return fmt.Sprintf("read_json('%s', format = 'newline_delimited', union_by_name = true)", files)
+	}
+	return fmt.Sprintf("'%s'", files)
 }
 
-func (c *cache) setWait(key string, value *sync.WaitGroup) {
-	c.wait.Store(key, value)
+func dropViews(frames []*sdk.Frame) []string {
+	commands := []string{}
+	dropped := map[string]bool{}
+	for _, frame := range frames {
+		if dropped[frame.RefID] {
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("DROP VIEW IF EXISTS %s;", frame.RefID))
+		dropped[frame.RefID] = true
+	}
+	return commands
 }
 
-func (c *cache) getWait(key string) (*sync.WaitGroup, bool) {
-	val, ok := c.wait.Load(key)
-	if !ok {
-		return nil, false
+func (f *FrameData) data(key CacheKey, frames []*sdk.Frame) (Dirs, func(), bool, error) {
+	if f.cache != nil {
+		if d, release, ok := f.cache.Get(key); ok {
+			err := dirsExist(d)
+			if err == nil {
+				return d, release, true, nil
+			}
+			logger.Warn("cached parquet files are missing, converting frames again", "name", key.Name, "error", err)
+			release()
+			f.cache.Remove(key)
+		}
 	}
-	return val.(*sync.WaitGroup), true
+
+	opts := f.db.parquet
+	opts.Chunk = f.db.chunk
+	dirs, err := toFiles(frames, f.db.format, opts)
+	return dirs, nil, false, err
 }
 
-func (c *cache) deleteWait(key string) {
-	c.wait.Delete(key)
+// dirsExist returns an error if a parquet directory was removed
+func dirsExist(dirs Dirs) error {
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,172 @@
+package duck
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/scottlepp/go-duck/duck/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyToParquet(t *testing.T) {
+	columns := []data.Column{
+		{Name: "value", Type: "VARCHAR"},
+		{Name: "count", Type: "BIGINT"},
+		{Name: "amount", Type: "DECIMAL(18,3)"},
+		{Name: "day", Type: "DATE"},
+		{Name: "ts", Type: "TIMESTAMP WITH TIME ZONE"},
+		{Name: "id", Type: "UUID"},
+		{Name: `my "list"`, Type: "INTEGER[]"},
+	}
+	cmd := copyToParquet("select * from foo;", columns, "/tmp/duck'1/results.parquet")
+
+	expected := `COPY (SELECT CAST("value" AS VARCHAR) AS "value", ` +
+		`"count" AS "count", ` +
+		`CAST("amount" AS DOUBLE) AS "amount", ` +
+		`CAST("day" AS TIMESTAMP_NS) AS "day", ` +
+		`CAST(timezone('UTC', "ts") AS TIMESTAMP_NS) AS "ts", ` +
+		`CAST("id" AS VARCHAR) AS "id", ` +
+		`encode(CAST(to_json("my ""list""") AS VARCHAR)) AS "my ""list""" ` +
+		"FROM (select * from foo\n)) TO '/tmp/duck''1/results.parquet' (FORMAT PARQUET);"
+	assert.Equal(t, expected, cmd)
+
+	cmd = copyToParquet("select * from foo -- note", columns[:1], "/tmp/results.parquet")
+	assert.True(t, strings.HasSuffix(cmd, "FROM (select * from foo -- note\n)) TO '/tmp/results.parquet' (FORMAT PARQUET);"))
+}
+
+func testFrames() []*sdk.Frame {
+	frame := sdk.NewFrame("foo", sdk.NewField("value", nil, []string{"test"}))
+	frame.RefID = "foo"
+	return []*sdk.Frame{frame}
+}
+
+// failingFrameData returns frame data whose queries fail because duckdb can not be started
+func failingFrameData(t *testing.T, cacheDuration int) (*FrameData, string) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	db := NewInMemoryDB(Opts{Exe: filepath.Join(dir, "missing"), CacheDuration: cacheDuration})
+	t.Cleanup(func() { _ = db.Close() })
+	return &FrameData{cache: db.cache, db: db}, dir
+}
+
+func assertEmptyDir(t *testing.T, dir string) {
+	assert.Eventually(t, func() bool {
+		entries, err := os.ReadDir(dir)
+		return err == nil && len(entries) == 0
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, "DESCRIBE select * from foo\n;", describe("select * from foo;"))
+	// the semicolon is not commented out
+	assert.Equal(t, "DESCRIBE select * from foo -- note\n;", describe("select * from foo -- note"))
+}
+
+func TestFrameDataConversionError(t *testing.T) {
+	db := NewInMemoryDB(Opts{Compression: "lzo"})
+	fd := FrameData{cache: db.cache, db: db}
+
+	_, _, err := fd.Query(context.Background(), "foo", "select * from foo", testFrames())
+
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindConversion, qerr.Kind)
+	var convErr *data.ConversionError
+	assert.True(t, errors.As(err, &convErr))
+}
+
+func TestFrameDataQueryError(t *testing.T) {
+	fd, dir := failingFrameData(t, 0)
+
+	res, cached, err := fd.Query(context.Background(), "foo", "select * from foo", testFrames())
+	assert.Equal(t, "", res)
+	assert.False(t, cached)
+
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindExecutable, qerr.Kind)
+	assertEmptyDir(t, dir)
+}
+
+func TestFrameDataQueryErrorNotCached(t *testing.T) {
+	fd, dir := failingFrameData(t, 10)
+
+	_, _, err := fd.Query(context.Background(), "foo", "select * from foo", testFrames())
+	assert.NotNil(t, err)
+
+	// the failed conversion is not cached, and its files are removed without waiting for the cache duration
+	key, err := fd.key("foo", "select * from foo", testFrames())
+	assert.Nil(t, err)
+	_, _, ok := fd.cache.Get(key)
+	assert.False(t, ok)
+	assertEmptyDir(t, dir)
+}
+
+func TestFrameDataQueryErrorCached(t *testing.T) {
+	fd, dir := failingFrameData(t, 10)
+	cachedDir := filepath.Join(dir, "cached")
+	assert.Nil(t, os.Mkdir(cachedDir, 0700))
+	key, err := fd.key("foo", "select * from foo", testFrames())
+	assert.Nil(t, err)
+	fd.cache.Set(key, Dirs{"foo": cachedDir})
+
+	_, cached, err := fd.Query(context.Background(), "foo", "select * from foo", testFrames())
+	assert.NotNil(t, err)
+	assert.True(t, cached)
+
+	// the entry is dropped so the next query converts the frames again
+	_, _, ok := fd.cache.Get(key)
+	assert.False(t, ok)
+}
+
+func TestFrameDataStaleCache(t *testing.T) {
+	fd, dir := failingFrameData(t, 10)
+	key, err := fd.key("foo", "select * from foo", testFrames())
+	assert.Nil(t, err)
+	fd.cache.Set(key, Dirs{"foo": filepath.Join(dir, "removed")})
+
+	// the missing files are not queried, the frames are converted again
+	_, cached, err := fd.Query(context.Background(), "foo", "select * from foo", testFrames())
+	assert.False(t, cached)
+
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindExecutable, qerr.Kind)
+
+	_, _, ok := fd.cache.Get(key)
+	assert.False(t, ok)
+	assertEmptyDir(t, dir)
+}
+
+func TestCreateViews(t *testing.T) {
+	dirs := Dirs{"foo": "/tmp/duck1"}
+	tests := map[string]string{
+		"":        `CREATE OR REPLACE VIEW foo AS (SELECT * from '/tmp/duck1/*.parquet');`,
+		"csv":     `CREATE OR REPLACE VIEW foo AS (SELECT * from read_csv('/tmp/duck1/*.csv', header = true, auto_detect = true, union_by_name = true));`,
+		"ndjson":  `CREATE OR REPLACE VIEW foo AS (SELECT * from read_json('/tmp/duck1/*.ndjson', format = 'newline_delimited', union_by_name = true));`,
+		"feather": `CREATE OR REPLACE VIEW foo AS (SELECT * from read_arrow('/tmp/duck1/*.arrow'));`,
+	}
+	for format, expected := range tests {
+		cmds := createViews(testFrames(), dirs, format)
+		assert.Equal(t, expected, cmds[len(cmds)-1], format)
+	}
+}
+
+func TestLoadExtensions(t *testing.T) {
+	assert.Empty(t, loadExtensions("parquet", true))
+	assert.Equal(t, []string{"LOAD nanoarrow;"}, loadExtensions("arrow", false))
+
+	// containers install the extension once, in a directory on the host
+	cmds := loadExtensions("feather", true)
+	assert.Equal(t, []string{
+		"SET extension_directory = '" + extensionDir + "';",
+		"LOAD nanoarrow;",
+	}, cmds)
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package duck
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryFramesStatement(t *testing.T) {
+	// the query is on line 3, after the .mode and CREATE VIEW commands
+	exe := fakeDuckDB(t, astScript+printScript("", "Error: near line 3: Catalog Error: Table with name bar does not exist!\n", 1))
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	_, _, err := db.QueryFrames("foo", "select * from bar", testFrames())
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindQuery, qerr.Kind)
+	assert.Equal(t, 0, qerr.Statement)
+
+	// an error creating the view is not a statement of the caller
+	exe = fakeDuckDB(t, astScript+printScript("", "Error: near line 2: IO Error: No files found\n", 1))
+	db = NewInMemoryDB(Opts{Exe: exe})
+	_, _, err = db.QueryFrames("foo", "select * from foo", testFrames())
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, -1, qerr.Statement)
+}
+
+func TestInstallExtensionsOnce(t *testing.T) {
+	// every run is logged, as the input only keeps the last one
+	log := filepath.Join(t.TempDir(), "log")
+	exe := fakeDuckDB(t, "printf '%s\\n' \"$input\" >> "+shellQuote(log)+"\n"+astScript+printScript("[]", "", 0))
+	db := NewInMemoryDB(Opts{Exe: exe, Format: "arrow"})
+
+	for i := 0; i < 2; i++ {
+		_, _, err := db.QueryFrames("foo", "select * from foo", testFrames())
+		assert.Nil(t, err)
+	}
+	b, err := os.ReadFile(log)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(b), "INSTALL nanoarrow"))
+	assert.Equal(t, 2, strings.Count(string(b), "LOAD nanoarrow"))
+}
+
+func TestQueryFramesNoAst(t *testing.T) {
+	exe := fakeDuckDB(t, printScript("[]", "", 0))
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	_, _, err := db.QueryFrames("foo", "select * from foo", testFrames())
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindValidation, qerr.Kind)
+}
@@ -0,0 +1,264 @@
+package duck
+
+import (
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// labelKeys returns the names of the labels of the frames
+func labelKeys(frames []*sdk.Frame) map[string]bool {
+	keys := map[string]bool{}
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			for key := range field.Labels {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// labelColumns returns the indexes of the string fields named after a label key
+func labelColumns(f *sdk.Frame, keys map[string]bool) []int {
+	columns := []int{}
+	for i, field := range f.Fields {
+		if !keys[field.Name] {
+			continue
+		}
+		if field.Type() == sdk.FieldTypeString || field.Type() == sdk.FieldTypeNullableString {
+			columns = append(columns, i)
+		}
+	}
+	return columns
+}
+
+// labelGroup is the rows of a frame with the same values in the label columns
+type labelGroup struct {
+	labels sdk.Labels
+	rows   []int
+}
+
+// groupByLabels groups the rows of the frame by the values of the label columns,
+// in the order the label values first appear
+func groupByLabels(f *sdk.Frame, columns []int) []*labelGroup {
+	groups := []*labelGroup{}
+	byKey := map[string]*labelGroup{}
+	rows, _ := f.RowLen()
+	for row := 0; row < rows; row++ {
+		labels := sdk.Labels{}
+		for _, col := range columns {
+			field := f.Fields[col]
+			if v, ok := field.ConcreteAt(row); ok {
+				labels[field.Name] = v.(string)
+			}
+		}
+		key := labels.String()
+		group, ok := byKey[key]
+		if !ok {
+			group = &labelGroup{labels: labels}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.rows = append(group.rows, row)
+	}
+	return groups
+}
+
+// restoreLabels turns the columns of the result that are named after labels of the input frames
+// back into labels of the value fields. Long time series are already converted to wide with labels.
+// When the labels are the same on every row the columns are removed and their values set as labels.
+// When each set of labels has a single row, such as the results of an aggregate grouped by the labels,
+// the frame is converted to numeric wide, with a field for each set of labels.
+// Other results keep the label columns, and can be split into a frame per set of labels.
+func restoreLabels(f *sdk.Frame, frames []*sdk.Frame) {
+	columns := labelColumns(f, labelKeys(frames))
+	if len(columns) == 0 || len(columns) == len(f.Fields) {
+		// there are no labels, or nothing to label
+		return
+	}
+	groups := groupByLabels(f, columns)
+	switch {
+	case len(groups) <= 1:
+		values := valueFields(f, columns)
+		if len(groups) == 1 {
+			for _, field := range values {
+				field.Labels = mergeLabels(field.Labels, groups[0].labels)
+			}
+		}
+		f.Fields = values
+	case len(groups) == f.Rows():
+		f.Fields = numericWide(f, columns, groups)
+		if f.Meta == nil {
+			f.Meta = &sdk.FrameMeta{}
+		}
+		f.Meta.Type = sdk.FrameTypeNumericWide
+	default:
+		logger.Debug("label columns have several rows for each set of labels, keeping them as columns", "frame", f.Name)
+	}
+}
+
+// valueFields returns the fields that are not label columns
+func valueFields(f *sdk.Frame, columns []int) []*sdk.Field {
+	isLabel := map[int]bool{}
+	for _, col := range columns {
+		isLabel[col] = true
+	}
+	fields := []*sdk.Field{}
+	for i, field := range f.Fields {
+		if !isLabel[i] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// numericWide returns a single row field for each value field and set of labels
+func numericWide(f *sdk.Frame, columns []int, groups []*labelGroup) []*sdk.Field {
+	fields := []*sdk.Field{}
+	for _, group := range groups {
+		for _, field := range valueFields(f, columns) {
+			value := sdk.NewFieldFromFieldType(field.Type(), 1)
+			value.Name = field.Name
+			value.Config = field.Config
+			value.Labels = mergeLabels(field.Labels, group.labels)
+			value.Set(0, field.At(group.rows[0]))
+			fields = append(fields, value)
+		}
+	}
+	return fields
+}
+
+// mergeLabels returns a copy of the labels with the extra labels added
+func mergeLabels(labels sdk.Labels, extra sdk.Labels) sdk.Labels {
+	merged := labels.Copy()
+	if merged == nil {
+		merged = sdk.Labels{}
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
+// splitByLabels returns a frame for each set of labels in the frame. Wide time series are split by
+// the labels of their value fields, and other frames by the values of their label columns. Only the
+// keys are used when they are given, otherwise the label keys of the input frames. The frames are
+// named after the input frame of their labels.
+func splitByLabels(f *sdk.Frame, frames []*sdk.Frame, keys []string) sdk.Frames {
+	labelSet := labelKeys(frames)
+	if len(keys) > 0 {
+		labelSet = map[string]bool{}
+		for _, key := range keys {
+			labelSet[key] = true
+		}
+	}
+
+	var split sdk.Frames
+	if f.TimeSeriesSchema().Type == sdk.TimeSeriesTypeWide {
+		split = splitSeries(f, labelSet)
+	} else if columns := labelColumns(f, labelSet); len(columns) > 0 && len(columns) < len(f.Fields) {
+		split = splitRows(f, columns)
+	}
+	if len(split) == 0 {
+		return sdk.Frames{f}
+	}
+
+	for _, frame := range split {
+		frame.Name = sourceName(frames, frame, f.Name)
+		frame.RefID = f.RefID
+		if f.Meta != nil {
+			meta := *f.Meta
+			meta.Notices = append([]sdk.Notice{}, f.Meta.Notices...)
+			frame.Meta = &meta
+		}
+		if frame.TimeSeriesSchema().Type != sdk.TimeSeriesTypeNot {
+			if frame.Meta == nil {
+				frame.Meta = &sdk.FrameMeta{}
+			}
+			frame.Meta.Type = sdk.FrameTypeTimeSeriesMulti
+		}
+	}
+	return split
+}
+
+// splitSeries returns a frame with the time field for each set of labels of the value fields
+func splitSeries(f *sdk.Frame, keys map[string]bool) sdk.Frames {
+	schema := f.TimeSeriesSchema()
+	frames := sdk.Frames{}
+	byKey := map[string]*sdk.Frame{}
+	for _, i := range schema.ValueIndices {
+		field := f.Fields[i]
+		labels := sdk.Labels{}
+		for k, v := range field.Labels {
+			if keys[k] {
+				labels[k] = v
+			}
+		}
+		key := labels.String()
+		frame, ok := byKey[key]
+		if !ok {
+			// each frame has its own time field, so changing one frame does not change the others
+			frame = sdk.NewFrame("", copyField(f.Fields[schema.TimeIndex]))
+			byKey[key] = frame
+			frames = append(frames, frame)
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+	if len(frames) < 2 {
+		return nil
+	}
+	return frames
+}
+
+// splitRows returns a frame of the rows for each set of values in the label columns,
+// with the values as labels of the other fields
+func splitRows(f *sdk.Frame, columns []int) sdk.Frames {
+	frames := sdk.Frames{}
+	values := valueFields(f, columns)
+	for _, group := range groupByLabels(f, columns) {
+		fields := make([]*sdk.Field, len(values))
+		for i, field := range values {
+			fields[i] = sdk.NewFieldFromFieldType(field.Type(), len(group.rows))
+			fields[i].Name = field.Name
+			fields[i].Config = field.Config
+			fields[i].Labels = mergeLabels(field.Labels, group.labels)
+			for j, row := range group.rows {
+				fields[i].Set(j, field.At(row))
+			}
+		}
+		frames = append(frames, sdk.NewFrame("", fields...))
+	}
+	return frames
+}
+
+// sourceName returns the name of the first input frame with a field that has the labels
+// of the frame, or name when there is none
+func sourceName(frames []*sdk.Frame, frame *sdk.Frame, name string) string {
+	var labels sdk.Labels
+	for _, field := range frame.Fields {
+		if len(field.Labels) > 0 {
+			labels = field.Labels
+			break
+		}
+	}
+	if len(labels) == 0 {
+		return name
+	}
+	for _, source := range frames {
+		for _, field := range source.Fields {
+			if len(field.Labels) > 0 && hasLabels(field.Labels, labels) && source.Name != "" {
+				return source.Name
+			}
+		}
+	}
+	return name
+}
+
+// hasLabels returns true if labels has the keys and values of the other labels
+func hasLabels(labels sdk.Labels, other sdk.Labels) bool {
+	for k, v := range other {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package duck
+
+import (
+	"testing"
+	"time"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func labeledFrames() []*sdk.Frame {
+	frame := sdk.NewFrame("foo", sdk.NewField("value", sdk.Labels{"host": "a", "region": "eu"}, []float64{1}))
+	frame.RefID = "A"
+	return []*sdk.Frame{frame}
+}
+
+func TestRestoreLabelsSingleSet(t *testing.T) {
+	f := sdk.NewFrame("foo",
+		sdk.NewField("host", nil, []string{"a", "a"}),
+		sdk.NewField("value", nil, []float64{1, 2}),
+	)
+	restoreLabels(f, labeledFrames())
+
+	assert.Len(t, f.Fields, 1)
+	assert.Equal(t, "value", f.Fields[0].Name)
+	assert.Equal(t, sdk.Labels{"host": "a"}, f.Fields[0].Labels)
+	assert.Equal(t, 2, f.Rows())
+}
+
+func TestRestoreLabelsNumericWide(t *testing.T) {
+	f := sdk.NewFrame("foo",
+		sdk.NewField("host", nil, []string{"a", "b"}),
+		sdk.NewField("region", nil, []*string{strPtr("eu"), nil}),
+		sdk.NewField("avg", nil, []float64{1.5, 2.5}),
+	)
+	restoreLabels(f, labeledFrames())
+
+	assert.Equal(t, sdk.FrameTypeNumericWide, f.Meta.Type)
+	assert.Len(t, f.Fields, 2)
+	assert.Equal(t, sdk.Labels{"host": "a", "region": "eu"}, f.Fields[0].Labels)
+	assert.Equal(t, 1.5, f.Fields[0].At(0))
+	assert.Equal(t, sdk.Labels{"host": "b"}, f.Fields[1].Labels)
+	assert.Equal(t, 2.5, f.Fields[1].At(0))
+}
+
+func TestRestoreLabelsKeepsColumns(t *testing.T) {
+	// several rows for each host can't be labels of a single frame
+	f := sdk.NewFrame("foo",
+		sdk.NewField("host", nil, []string{"a", "a", "b"}),
+		sdk.NewField("value", nil, []float64{1, 2, 3}),
+	)
+	restoreLabels(f, labeledFrames())
+	assert.Len(t, f.Fields, 2)
+	assert.Nil(t, f.Fields[1].Labels)
+
+	// a result of only labels has no fields to label
+	f = sdk.NewFrame("foo", sdk.NewField("host", nil, []string{"a"}))
+	restoreLabels(f, labeledFrames())
+	assert.Len(t, f.Fields, 1)
+
+	// columns that are not labels of the input are kept
+	f = sdk.NewFrame("foo",
+		sdk.NewField("name", nil, []string{"a"}),
+		sdk.NewField("value", nil, []float64{1}),
+	)
+	restoreLabels(f, labeledFrames())
+	assert.Len(t, f.Fields, 2)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestSplitByLabelsSeries(t *testing.T) {
+	ts := time.Date(2024, 2, 23, 9, 1, 54, 0, time.UTC)
+	source := sdk.NewFrame("cpu", sdk.NewField("value", sdk.Labels{"host": "a"}, []float64{1}))
+	f := sdk.NewFrame("foo",
+		sdk.NewField("time", nil, []time.Time{ts}),
+		sdk.NewField("value", sdk.Labels{"host": "a"}, []float64{1}),
+		sdk.NewField("value", sdk.Labels{"host": "b"}, []float64{2}),
+	)
+	f.AppendNotices(sdk.Notice{Text: "Results retrieved from cache"})
+
+	frames := splitByLabels(f, []*sdk.Frame{source}, nil)
+	assert.Len(t, frames, 2)
+	assert.Equal(t, "cpu", frames[0].Name)
+	assert.Equal(t, "foo", frames[1].Name)
+	for i, frame := range frames {
+		assert.Equal(t, sdk.FrameTypeTimeSeriesMulti, frame.Meta.Type)
+		assert.Len(t, frame.Meta.Notices, 1)
+		assert.Len(t, frame.Fields, 2)
+		assert.Equal(t, "time", frame.Fields[0].Name)
+		assert.Equal(t, f.Fields[i+1], frame.Fields[1])
+	}
+
+	// the frames don't share the time field
+	assert.NotSame(t, frames[0].Fields[0], frames[1].Fields[0])
+	frames[0].Fields[0].Name = "t"
+	frames[0].Fields[0].Set(0, ts.Add(time.Hour))
+	assert.Equal(t, "time", frames[1].Fields[0].Name)
+	assert.Equal(t, ts, frames[1].Fields[0].At(0))
+}
+
+func TestSplitByLabelsRows(t *testing.T) {
+	f := sdk.NewFrame("foo",
+		sdk.NewField("host", nil, []string{"a", "b", "a"}),
+		sdk.NewField("region", nil, []string{"eu", "eu", "us"}),
+		sdk.NewField("value", nil, []float64{1, 2, 3}),
+	)
+
+	// the chosen labels are used instead of the labels of the input
+	frames := splitByLabels(f, labeledFrames(), []string{"host"})
+	assert.Len(t, frames, 2)
+	assert.Equal(t, sdk.Labels{"host": "a"}, frames[0].Fields[1].Labels)
+	assert.Equal(t, "region", frames[0].Fields[0].Name)
+	assert.Equal(t, 2, frames[0].Rows())
+	assert.Equal(t, 3.0, frames[0].Fields[1].At(1))
+	assert.Equal(t, 1, frames[1].Rows())
+	assert.Nil(t, frames[0].Meta)
+}
+
+func TestSplitByLabelsNone(t *testing.T) {
+	f := sdk.NewFrame("foo", sdk.NewField("value", nil, []float64{1, 2}))
+	frames := splitByLabels(f, labeledFrames(), nil)
+	assert.Equal(t, sdk.Frames{f}, frames)
+}
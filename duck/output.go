@@ -0,0 +1,537 @@
+package duck
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// output modes of the duckdb cli
+const (
+	ModeJSON      = "json"
+	ModeJSONLines = "jsonlines"
+	ModeCSV       = "csv"
+	ModeList      = "list"
+	ModeLine      = "line"
+	ModeMarkdown  = "markdown"
+	ModeHTML      = "html"
+	ModeBox       = "box"
+	ModeTable     = "table"
+)
+
+// Result is the columns and rows of a query. Values parsed from json modes are json values,
+// with numbers as json.Number. Values parsed from text modes are strings, and NULL is "".
+type Result struct {
+	Columns []string
+	Rows    [][]any
+}
+
+type outputParser func(output string) (*Result, error)
+
+var parsers = map[string]outputParser{
+	ModeJSON:      parseJSON,
+	ModeJSONLines: parseJSONLines,
+	ModeCSV:       parseCSV,
+	ModeList:      parseList,
+	ModeLine:      parseLine,
+	ModeMarkdown:  parseMarkdown,
+	ModeHTML:      parseHTML,
+}
+
+type outputRenderer func(b *strings.Builder, r *Result) error
+
+var renderers = map[string]outputRenderer{
+	ModeJSON:      renderJSON,
+	ModeJSONLines: renderJSONLines,
+	ModeCSV:       renderCSV,
+	ModeList:      renderList,
+	ModeLine:      renderLine,
+	ModeMarkdown:  renderMarkdown,
+	ModeHTML:      renderHTML,
+	ModeBox:       renderBox,
+	ModeTable:     renderTable,
+}
+
+// QueryResult runs the query with the args, and parses the output in the mode of the database.
+// Modes that can not be parsed, such as box and table, are queried as json.
+func (d *DuckDB) QueryResult(query string, args ...any) (*Result, error) {
+	return d.QueryResultContext(context.Background(), query, args...)
+}
+
+// QueryResultContext is QueryResult with cancellation.
+func (d *DuckDB) QueryResultContext(ctx context.Context, query string, args ...any) (*Result, error) {
+	commands := []string{terminate(query)}
+	if len(args) > 0 {
+		var err error
+		if commands, err = prepare(query, args); err != nil {
+			return nil, err
+		}
+	}
+	mode := d.mode
+	if parsers[mode] == nil {
+		mode = ModeJSON
+		commands = append([]string{".mode " + mode}, commands...)
+	}
+	out, err := d.RunCommandsContext(ctx, commands)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOutput(mode, out)
+}
+
+// ParseOutput parses the output of a query in the mode: json, jsonlines, csv, list, line, markdown or html.
+// The output must have a header, which duckdb prints by default. Text modes do not escape their separators,
+// so values that contain them can not be parsed, use json or csv for those.
+func ParseOutput(mode string, output string) (*Result, error) {
+	parse, ok := parsers[mode]
+	if !ok {
+		return nil, validationError("output of mode %s can not be parsed", mode)
+	}
+	r, err := parse(output)
+	if err != nil {
+		return nil, conversionError(fmt.Errorf("error parsing %s output: %w", mode, err))
+	}
+	return r, nil
+}
+
+// Maps returns the rows as maps of column name to value
+func (r *Result) Maps() []map[string]any {
+	maps := make([]map[string]any, len(r.Rows))
+	for i, row := range r.Rows {
+		maps[i] = make(map[string]any, len(r.Columns))
+		for j, col := range r.Columns {
+			if j < len(row) {
+				maps[i][col] = row[j]
+			}
+		}
+	}
+	return maps
+}
+
+// Render prints the result in the mode, as duckdb would: json, jsonlines, csv, list, line, markdown,
+// html, box or table. NULL is printed as "NULL" in line, markdown, box and table modes, for logs and cli output.
+// Every row must have a value for each column.
+func (r *Result) Render(mode string) (string, error) {
+	render, ok := renderers[mode]
+	if !ok {
+		return "", validationError("mode %s can not be rendered", mode)
+	}
+	for i, row := range r.Rows {
+		if len(row) != len(r.Columns) {
+			return "", validationError("row %d has %d values, expected %d", i, len(row), len(r.Columns))
+		}
+	}
+	var b strings.Builder
+	if err := render(&b, r); err != nil {
+		return "", conversionError(err)
+	}
+	return b.String(), nil
+}
+
+func parseJSON(output string) (*Result, error) {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		// duckdb prints nothing when there are no rows
+		return &Result{}, nil
+	}
+	var objects []json.RawMessage
+	if err := json.Unmarshal([]byte(output), &objects); err != nil {
+		return nil, err
+	}
+	return parseObjects(objects)
+}
+
+func parseJSONLines(output string) (*Result, error) {
+	objects := []json.RawMessage{}
+	for _, line := range strings.Split(output, newline) {
+		if line = strings.TrimSpace(line); line != "" {
+			objects = append(objects, json.RawMessage(line))
+		}
+	}
+	return parseObjects(objects)
+}
+
+// parseObjects returns the values of json objects, in the order of the keys of the first object
+func parseObjects(objects []json.RawMessage) (*Result, error) {
+	r := &Result{Rows: make([][]any, len(objects))}
+	for i, object := range objects {
+		if i == 0 {
+			keys, err := objectKeys(object)
+			if err != nil {
+				return nil, err
+			}
+			r.Columns = keys
+		}
+		decoder := json.NewDecoder(bytes.NewReader(object))
+		decoder.UseNumber()
+		values := map[string]any{}
+		if err := decoder.Decode(&values); err != nil {
+			return nil, err
+		}
+		r.Rows[i] = make([]any, len(r.Columns))
+		for j, col := range r.Columns {
+			r.Rows[i][j] = values[col]
+		}
+	}
+	return r, nil
+}
+
+func parseCSV(output string) (*Result, error) {
+	records, err := csv.NewReader(strings.NewReader(output)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return textResult(records)
+}
+
+func parseList(output string) (*Result, error) {
+	records := [][]string{}
+	for _, line := range lines(output) {
+		records = append(records, strings.Split(line, "|"))
+	}
+	return textResult(records)
+}
+
+// parseLine parses rows of "column = value" lines, separated by empty lines
+func parseLine(output string) (*Result, error) {
+	r := &Result{}
+	var row []any
+	column := 0
+	for _, line := range strings.Split(strings.ReplaceAll(output, "\r\n", newline), newline) {
+		if line == "" {
+			row, column = nil, 0
+			continue
+		}
+		name, value, ok := strings.Cut(line, " = ")
+		if !ok {
+			if row == nil || column == 0 {
+				return nil, fmt.Errorf("unexpected line %q", line)
+			}
+			// a value with line breaks
+			row[column-1] = row[column-1].(string) + newline + line
+			continue
+		}
+		name = strings.TrimLeft(name, " ")
+		if row == nil {
+			row = make([]any, 0, len(r.Columns))
+			r.Rows = append(r.Rows, row)
+		}
+		if len(r.Rows) == 1 {
+			r.Columns = append(r.Columns, name)
+		} else if column >= len(r.Columns) || r.Columns[column] != name {
+			return nil, fmt.Errorf("unexpected column %q in row %d", name, len(r.Rows)-1)
+		}
+		row = append(row, value)
+		r.Rows[len(r.Rows)-1] = row
+		column++
+	}
+	for i, row := range r.Rows {
+		if len(row) != len(r.Columns) {
+			return nil, fmt.Errorf("row %d has %d values, expected %d", i, len(row), len(r.Columns))
+		}
+	}
+	return r, nil
+}
+
+var markdownSeparator = regexp.MustCompile(`^\|(\s*:?-+:?\s*\|)+$`)
+
+func parseMarkdown(output string) (*Result, error) {
+	records := [][]string{}
+	for i, line := range lines(output) {
+		line = strings.TrimSpace(line)
+		if i == 1 {
+			if !markdownSeparator.MatchString(line) {
+				return nil, fmt.Errorf("expected a header separator, got %q", line)
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "|") || !strings.HasSuffix(line, "|") || len(line) < 2 {
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+		cells := strings.Split(line[1:len(line)-1], "|")
+		for j := range cells {
+			cells[j] = strings.TrimSpace(cells[j])
+		}
+		records = append(records, cells)
+	}
+	return textResult(records)
+}
+
+var (
+	htmlRow  = regexp.MustCompile(`(?is)<tr>(.*?)</tr>`)
+	htmlCell = regexp.MustCompile(`(?is)<t([hd])>(.*?)</t[hd]>`)
+)
+
+func parseHTML(output string) (*Result, error) {
+	r := &Result{}
+	for _, row := range htmlRow.FindAllStringSubmatch(output, -1) {
+		cells := htmlCell.FindAllStringSubmatch(row[1], -1)
+		values := make([]any, len(cells))
+		header := len(cells) > 0
+		for i, cell := range cells {
+			values[i] = html.UnescapeString(cell[2])
+			header = header && strings.EqualFold(cell[1], "h")
+		}
+		if header && r.Columns == nil {
+			for _, v := range values {
+				r.Columns = append(r.Columns, v.(string))
+			}
+			continue
+		}
+		if len(values) != len(r.Columns) {
+			return nil, fmt.Errorf("row %d has %d values, expected %d", len(r.Rows), len(values), len(r.Columns))
+		}
+		r.Rows = append(r.Rows, values)
+	}
+	return r, nil
+}
+
+// lines returns the lines of the output, without the empty line at the end
+func lines(output string) []string {
+	output = strings.TrimRight(strings.ReplaceAll(output, "\r\n", newline), newline)
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, newline)
+}
+
+// textResult returns a result with the first record as columns, and the others as rows of strings
+func textResult(records [][]string) (*Result, error) {
+	r := &Result{Rows: [][]any{}}
+	for i, record := range records {
+		if i == 0 {
+			r.Columns = record
+			continue
+		}
+		if len(record) != len(r.Columns) {
+			return nil, fmt.Errorf("row %d has %d values, expected %d", i-1, len(record), len(r.Columns))
+		}
+		row := make([]any, len(record))
+		for j, v := range record {
+			row[j] = v
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	return r, nil
+}
+
+// text returns a value as it is printed by text modes
+func text(v any, null string) string {
+	switch v := v.(type) {
+	case nil:
+		return null
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	case []byte:
+		return string(v)
+	case map[string]any, []any:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+func renderJSON(b *strings.Builder, r *Result) error {
+	if len(r.Rows) == 0 {
+		return nil
+	}
+	b.WriteString("[")
+	for i, row := range r.Rows {
+		if i > 0 {
+			b.WriteString(",\n")
+		}
+		if err := writeObject(b, r.Columns, row); err != nil {
+			return err
+		}
+	}
+	b.WriteString("]\n")
+	return nil
+}
+
+func renderJSONLines(b *strings.Builder, r *Result) error {
+	for _, row := range r.Rows {
+		if err := writeObject(b, r.Columns, row); err != nil {
+			return err
+		}
+		b.WriteString(newline)
+	}
+	return nil
+}
+
+// writeObject writes the row as a json object with the keys in the order of the columns
+func writeObject(b *strings.Builder, columns []string, row []any) error {
+	b.WriteString("{")
+	for i, col := range columns {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, _ := json.Marshal(col)
+		value, err := json.Marshal(row[i])
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}")
+	return nil
+}
+
+func renderCSV(b *strings.Builder, r *Result) error {
+	w := csv.NewWriter(b)
+	if err := w.Write(r.Columns); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		if err := w.Write(textRow(row, "")); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func renderList(b *strings.Builder, r *Result) error {
+	b.WriteString(strings.Join(r.Columns, "|") + newline)
+	for _, row := range r.Rows {
+		b.WriteString(strings.Join(textRow(row, ""), "|") + newline)
+	}
+	return nil
+}
+
+func renderLine(b *strings.Builder, r *Result) error {
+	width := 0
+	for _, col := range r.Columns {
+		width = max(width, utf8.RuneCountInString(col))
+	}
+	for i, row := range r.Rows {
+		if i > 0 {
+			b.WriteString(newline)
+		}
+		for j, value := range textRow(row, "NULL") {
+			fmt.Fprintf(b, "%s%s = %s\n", strings.Repeat(" ", width-utf8.RuneCountInString(r.Columns[j])), r.Columns[j], value)
+		}
+	}
+	return nil
+}
+
+func renderHTML(b *strings.Builder, r *Result) error {
+	writeRow := func(cells []string, tag string) {
+		b.WriteString("<TR>")
+		for _, cell := range cells {
+			fmt.Fprintf(b, "<%s>%s</%s>\n", tag, html.EscapeString(cell), tag)
+		}
+		b.WriteString("</TR>\n")
+	}
+	writeRow(r.Columns, "TH")
+	for _, row := range r.Rows {
+		writeRow(textRow(row, ""), "TD")
+	}
+	return nil
+}
+
+func renderMarkdown(b *strings.Builder, r *Result) error {
+	rows, widths := columnar(r)
+	writeGridRow(b, centered(r.Columns, widths), widths, "|", "|", "|")
+	b.WriteString("|")
+	for _, w := range widths {
+		b.WriteString(strings.Repeat("-", w+2) + "|")
+	}
+	b.WriteString(newline)
+	for _, row := range rows {
+		writeGridRow(b, row, widths, "|", "|", "|")
+	}
+	return nil
+}
+
+func renderBox(b *strings.Builder, r *Result) error {
+	rows, widths := columnar(r)
+	writeGridLine(b, widths, "┌", "┬", "┐", "─")
+	writeGridRow(b, centered(r.Columns, widths), widths, "│", "│", "│")
+	writeGridLine(b, widths, "├", "┼", "┤", "─")
+	for _, row := range rows {
+		writeGridRow(b, row, widths, "│", "│", "│")
+	}
+	writeGridLine(b, widths, "└", "┴", "┘", "─")
+	return nil
+}
+
+func renderTable(b *strings.Builder, r *Result) error {
+	rows, widths := columnar(r)
+	writeGridLine(b, widths, "+", "+", "+", "-")
+	writeGridRow(b, centered(r.Columns, widths), widths, "|", "|", "|")
+	writeGridLine(b, widths, "+", "+", "+", "-")
+	for _, row := range rows {
+		writeGridRow(b, row, widths, "|", "|", "|")
+	}
+	writeGridLine(b, widths, "+", "+", "+", "-")
+	return nil
+}
+
+// columnar returns the rows as text, and the width of each column
+func columnar(r *Result) ([][]string, []int) {
+	widths := make([]int, len(r.Columns))
+	for i, col := range r.Columns {
+		widths[i] = utf8.RuneCountInString(col)
+	}
+	rows := make([][]string, len(r.Rows))
+	for i, row := range r.Rows {
+		rows[i] = textRow(row, "NULL")
+		for j, value := range rows[i] {
+			widths[j] = max(widths[j], utf8.RuneCountInString(value))
+		}
+	}
+	return rows, widths
+}
+
+// centered pads the headers to the middle of their column, as duckdb does
+func centered(columns []string, widths []int) []string {
+	headers := make([]string, len(columns))
+	for i, col := range columns {
+		pad := widths[i] - utf8.RuneCountInString(col)
+		headers[i] = strings.Repeat(" ", pad/2) + col
+	}
+	return headers
+}
+
+func writeGridLine(b *strings.Builder, widths []int, left, middle, right, line string) {
+	b.WriteString(left)
+	for i, w := range widths {
+		if i > 0 {
+			b.WriteString(middle)
+		}
+		b.WriteString(strings.Repeat(line, w+2))
+	}
+	b.WriteString(right + newline)
+}
+
+func writeGridRow(b *strings.Builder, cells []string, widths []int, left, middle, right string) {
+	b.WriteString(left)
+	for i, cell := range cells {
+		if i > 0 {
+			b.WriteString(middle)
+		}
+		b.WriteString(" " + cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + " ")
+	}
+	b.WriteString(right + newline)
+}
+
+func textRow(row []any, null string) []string {
+	values := make([]string, len(row))
+	for i, v := range row {
+		values[i] = text(v, null)
+	}
+	return values
+}
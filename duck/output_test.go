@@ -0,0 +1,143 @@
+package duck
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOutput(t *testing.T) {
+	text := &Result{Columns: []string{"i", "s"}, Rows: [][]any{{"1", "a b"}, {"2", ""}}}
+	tests := []struct {
+		mode   string
+		output string
+		result *Result
+	}{
+		{ModeJSON, "[{\"s\":\"a\",\"i\":1},\n{\"s\":null,\"i\":9007199254740993}]\n", &Result{
+			Columns: []string{"s", "i"},
+			Rows:    [][]any{{"a", json.Number("1")}, {nil, json.Number("9007199254740993")}},
+		}},
+		{ModeJSON, "", &Result{}},
+		{ModeJSONLines, "{\"s\":\"a\",\"l\":[1]}\n{\"s\":\"b\",\"l\":null}\n", &Result{
+			Columns: []string{"s", "l"},
+			Rows:    [][]any{{"a", []any{json.Number("1")}}, {"b", nil}},
+		}},
+		{ModeCSV, "i,s\r\n1,\"a,\nb\"\r\n2,\r\n", &Result{Columns: []string{"i", "s"}, Rows: [][]any{{"1", "a,\nb"}, {"2", ""}}}},
+		{ModeList, "i|s\n1|a b\n2|\n", text},
+		{ModeLine, "i = 1\ns = a b\n\ni = 2\ns = \n", text},
+		{ModeLine, "name = x\n   i = 1\n", &Result{Columns: []string{"name", "i"}, Rows: [][]any{{"x", "1"}}}},
+		{ModeLine, "s = a\nb\n", &Result{Columns: []string{"s"}, Rows: [][]any{{"a\nb"}}}},
+		{ModeMarkdown, "| i |  s  |\n|---|-----|\n| 1 | a b |\n| 2 |     |\n", text},
+		{ModeHTML, "<TR><TH>i</TH>\n<TH>s</TH>\n</TR>\n<TR><TD>1</TD>\n<TD>a&lt;b&amp;&#39;</TD>\n</TR>\n", &Result{
+			Columns: []string{"i", "s"},
+			Rows:    [][]any{{"1", "a<b&'"}},
+		}},
+		{ModeCSV, "", &Result{Rows: [][]any{}}},
+	}
+	for _, test := range tests {
+		r, err := ParseOutput(test.mode, test.output)
+		assert.Nil(t, err, test.mode)
+		assert.Equal(t, test.result, r, test.mode)
+	}
+}
+
+func TestParseOutputErrors(t *testing.T) {
+	tests := []struct {
+		mode   string
+		output string
+		kind   ErrorKind
+	}{
+		{ModeBox, "┌───┐\n", KindValidation},
+		{"duckbox", "", KindValidation},
+		{ModeJSON, "[{\"i\":1}", KindConversion},
+		{ModeList, "i|s\n1|a|b\n", KindConversion},
+		{ModeLine, "oops\n", KindConversion},
+		{ModeLine, "i = 1\n\nj = 1\n", KindConversion},
+		{ModeMarkdown, "| i |\n| 1 |\n", KindConversion},
+		{ModeHTML, "<tr><th>i</th></tr><tr><td>1</td><td>2</td></tr>", KindConversion},
+	}
+	for _, test := range tests {
+		_, err := ParseOutput(test.mode, test.output)
+		var qerr *QueryError
+		if assert.True(t, errors.As(err, &qerr), test.output) {
+			assert.Equal(t, test.kind, qerr.Kind, test.output)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := &Result{Columns: []string{"id", "name"}, Rows: [][]any{{json.Number("1"), "ada"}, {json.Number("22"), nil}}}
+	tests := []struct {
+		mode   string
+		output string
+	}{
+		{ModeBox, "┌────┬──────┐\n" +
+			"│ id │ name │\n" +
+			"├────┼──────┤\n" +
+			"│ 1  │ ada  │\n" +
+			"│ 22 │ NULL │\n" +
+			"└────┴──────┘\n"},
+		{ModeTable, "+----+------+\n" +
+			"| id | name |\n" +
+			"+----+------+\n" +
+			"| 1  | ada  |\n" +
+			"| 22 | NULL |\n" +
+			"+----+------+\n"},
+		{ModeMarkdown, "| id | name |\n|----|------|\n| 1  | ada  |\n| 22 | NULL |\n"},
+		{ModeLine, "  id = 1\nname = ada\n\n  id = 22\nname = NULL\n"},
+		{ModeList, "id|name\n1|ada\n22|\n"},
+		{ModeCSV, "id,name\n1,ada\n22,\n"},
+		{ModeHTML, "<TR><TH>id</TH>\n<TH>name</TH>\n</TR>\n<TR><TD>1</TD>\n<TD>ada</TD>\n</TR>\n<TR><TD>22</TD>\n<TD></TD>\n</TR>\n"},
+		{ModeJSON, "[{\"id\":1,\"name\":\"ada\"},\n{\"id\":22,\"name\":null}]\n"},
+		{ModeJSONLines, "{\"id\":1,\"name\":\"ada\"}\n{\"id\":22,\"name\":null}\n"},
+	}
+	for _, test := range tests {
+		out, err := r.Render(test.mode)
+		assert.Nil(t, err, test.mode)
+		assert.Equal(t, test.output, out, test.mode)
+	}
+
+	wide := &Result{Columns: []string{"c"}, Rows: [][]any{{"wider"}}}
+	out, err := wide.Render(ModeTable)
+	assert.Nil(t, err)
+	assert.Equal(t, "+-------+\n|   c   |\n+-------+\n| wider |\n+-------+\n", out)
+
+	_, err = r.Render("duckbox")
+	assert.NotNil(t, err)
+
+	// rows that don't match the columns are errors in every mode
+	for _, rows := range [][][]any{{{"1", "ada", "x"}}, {{"1"}}} {
+		ragged := &Result{Columns: []string{"id", "name"}, Rows: rows}
+		for mode := range renderers {
+			_, err := ragged.Render(mode)
+			var qerr *QueryError
+			assert.ErrorAs(t, err, &qerr, mode)
+			assert.Equal(t, KindValidation, qerr.Kind, mode)
+		}
+	}
+}
+
+func TestRenderParse(t *testing.T) {
+	r := &Result{Columns: []string{"id", "name", "note"}, Rows: [][]any{{"1", "ada", "x"}, {"2", "bob", "y z"}}}
+	for mode := range parsers {
+		out, err := r.Render(mode)
+		assert.Nil(t, err, mode)
+		parsed, err := ParseOutput(mode, out)
+		assert.Nil(t, err, mode)
+		assert.Equal(t, r, parsed, mode)
+	}
+}
+
+func TestQueryResultDuckDB(t *testing.T) {
+	for mode := range parsers {
+		db := NewInMemoryDB(Opts{Mode: mode})
+		r, err := db.QueryResult("SELECT 1 AS i, 'a' AS s UNION ALL SELECT 2, 'b' ORDER BY i")
+		if !assert.Nil(t, err, mode) {
+			return
+		}
+		assert.Equal(t, []string{"i", "s"}, r.Columns, mode)
+		assert.Len(t, r.Rows, 2, mode)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package duck
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryResult(t *testing.T) {
+	exe := fakeDuckDB(t, printScript("i,s\n1,a\n", "", 0))
+	db := NewInMemoryDB(Opts{Exe: exe, Mode: ModeCSV})
+	r, err := db.QueryResult("SELECT * FROM t WHERE s = $1", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, &Result{Columns: []string{"i", "s"}, Rows: [][]any{{"1", "a"}}}, r)
+	assert.Equal(t, []map[string]any{{"i": "1", "s": "a"}}, r.Maps())
+
+	// modes that can not be parsed are queried as json
+	exe = fakeDuckDB(t, printScript("[{\"i\":1}]\n", "", 0))
+	db = NewInMemoryDB(Opts{Exe: exe, Mode: ModeBox})
+	r, err = db.QueryResult("SELECT 1 AS i")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]any{{json.Number("1")}}, r.Rows)
+}
+
+func TestQueryFramesMode(t *testing.T) {
+	// the fake prints json only when the last mode of the commands is json
+	exe := fakeDuckDB(t, `mode=$(printf '%s\n' "$input" | sed -n 's/^\.mode \([a-z]*\).*/\1/p' | tail -n 1)`+"\n"+
+		"if [ \"$mode\" != json ]; then printf 'value\\ntest\\n'; exit 0; fi\n"+
+		astScript+
+		`echo '[{"column_name":"value","column_type":"VARCHAR"}]'`+"\n"+
+		`echo '[{"value":"test"}]'`+"\n")
+	db := NewInMemoryDB(Opts{Exe: exe, Mode: ModeCSV})
+
+	res, _, err := db.QueryFrames("foo", "select * from foo", testFrames())
+	assert.Nil(t, err)
+	assert.Equal(t, "value\ntest\n", res)
+
+	frame, err := db.QueryFramesToFrames("foo", "select * from foo", testFrames())
+	assert.Nil(t, err)
+	if assert.NotNil(t, frame) {
+		assert.Equal(t, 1, frame.Rows())
+	}
+}
@@ -0,0 +1,235 @@
+package duck
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// NamedArg is a value for a named parameter, such as $name
+type NamedArg struct {
+	Name  string
+	Value any
+}
+
+// Named returns a value for the named parameter
+func Named(name string, value any) NamedArg {
+	return NamedArg{Name: name, Value: value}
+}
+
+var preparedSeq uint64
+
+// QueryArgs runs a query with values for its parameters. Positional parameters ($1, $2 ...) take
+// the args in order, named parameters ($name) take args created with Named. The query is prepared
+// and executed by duckdb, so values are never part of the sql text of the query.
+func (d *DuckDB) QueryArgs(query string, args ...any) (string, error) {
+	return d.QueryArgsContext(context.Background(), query, args...)
+}
+
+// QueryArgsContext is QueryArgs with cancellation.
+func (d *DuckDB) QueryArgsContext(ctx context.Context, query string, args ...any) (string, error) {
+	commands, err := prepare(query, args)
+	if err != nil {
+		return "", err
+	}
+	return d.RunCommandsContext(ctx, commands)
+}
+
+// prepare returns the commands that prepare the query, execute it with the args and deallocate it
+func prepare(query string, args []any) ([]string, error) {
+	values := make([]string, len(args))
+	named := 0
+	for i, arg := range args {
+		value := arg
+		if n, ok := arg.(NamedArg); ok {
+			if !validName(n.Name) {
+				return nil, validationError("invalid parameter name: %q", n.Name)
+			}
+			named++
+			value = n.Value
+		}
+		lit, err := Literal(value)
+		if err != nil {
+			return nil, validationError("invalid value for parameter %d: %s", i+1, err.Error())
+		}
+		if n, ok := arg.(NamedArg); ok {
+			lit = fmt.Sprintf("%s := %s", n.Name, lit)
+		}
+		values[i] = lit
+	}
+	if named > 0 && named < len(args) {
+		return nil, validationError("parameters must all be positional or all be named")
+	}
+
+	name := fmt.Sprintf("go_duck_%d", atomic.AddUint64(&preparedSeq, 1))
+	execute := "EXECUTE " + name
+	if len(values) > 0 {
+		execute += "(" + strings.Join(values, ", ") + ")"
+	}
+	return []string{
+		fmt.Sprintf("PREPARE %s AS %s;", name, closeQuery(query)),
+		execute + ";",
+		fmt.Sprintf("DEALLOCATE %s;", name),
+	}, nil
+}
+
+// validName returns true for parameter names that are plain identifiers
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Literal returns the value as a duckdb sql literal. Strings, numbers, booleans, times, durations,
+// byte slices (as BLOB), slices and arrays (as LIST), maps with string keys (as STRUCT), pointers,
+// driver.Valuer and nil (as NULL) are supported. Line breaks in strings are written with chr,
+// so a literal is always a single line.
+func Literal(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case driver.Valuer:
+		value, err := v.Value()
+		if err != nil {
+			return "", err
+		}
+		return Literal(value)
+	case string:
+		return stringLiteral(v)
+	case []byte:
+		if v == nil {
+			return "NULL", nil
+		}
+		var b strings.Builder
+		b.WriteString("'")
+		for _, c := range v {
+			fmt.Fprintf(&b, "\\x%02X", c)
+		}
+		b.WriteString("'::BLOB")
+		return b.String(), nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case json.Number:
+		if _, err := strconv.ParseFloat(string(v), 64); err != nil {
+			return "", fmt.Errorf("invalid number: %q", string(v))
+		}
+		return string(v), nil
+	case time.Time:
+		return fmt.Sprintf("'%s'::TIMESTAMPTZ", v.UTC().Format("2006-01-02 15:04:05.999999")+"+00"), nil
+	case time.Duration:
+		return fmt.Sprintf("to_microseconds(%d)", v.Microseconds()), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return strconv.FormatUint(u, 10) + "::UBIGINT", nil
+		}
+		return strconv.FormatUint(u, 10), nil
+	case reflect.Float32, reflect.Float64:
+		return floatLiteral(rv.Float(), rv.Type().Bits()), nil
+	case reflect.String:
+		return stringLiteral(rv.String())
+	case reflect.Bool:
+		return Literal(rv.Bool())
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return "NULL", nil
+		}
+		return Literal(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return "NULL", nil
+		}
+		items := make([]string, rv.Len())
+		for i := range items {
+			lit, err := Literal(rv.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			items[i] = lit
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return "", fmt.Errorf("unsupported map key type: %s", rv.Type().Key())
+		}
+		if rv.IsNil() {
+			return "NULL", nil
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		fields := make([]string, len(keys))
+		for i, k := range keys {
+			key, err := stringLiteral(k)
+			if err != nil {
+				return "", err
+			}
+			lit, err := Literal(rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface())
+			if err != nil {
+				return "", err
+			}
+			fields[i] = key + ": " + lit
+		}
+		return "{" + strings.Join(fields, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("unsupported type: %T", v)
+}
+
+func stringLiteral(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("string is not valid utf-8")
+	}
+	if strings.ContainsRune(s, 0) {
+		return "", fmt.Errorf("string contains a null character")
+	}
+	quoted := "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	if !strings.ContainsAny(s, "\r\n") {
+		return quoted, nil
+	}
+	quoted = strings.ReplaceAll(quoted, "\r", "' || chr(13) || '")
+	quoted = strings.ReplaceAll(quoted, "\n", "' || chr(10) || '")
+	return "(" + quoted + ")", nil
+}
+
+func floatLiteral(f float64, bits int) string {
+	cast := "DOUBLE"
+	if bits == 32 {
+		cast = "FLOAT"
+	}
+	switch {
+	case math.IsNaN(f):
+		return fmt.Sprintf("'nan'::%s", cast)
+	case math.IsInf(f, 1):
+		return fmt.Sprintf("'inf'::%s", cast)
+	case math.IsInf(f, -1):
+		return fmt.Sprintf("'-inf'::%s", cast)
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", strconv.FormatFloat(f, 'g', -1, bits), cast)
+}
@@ -0,0 +1,127 @@
+package duck
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type celsius float64
+
+func TestLiteral(t *testing.T) {
+	n := 42
+	var nilPtr *int
+	ts := time.Date(2024, 2, 23, 9, 1, 54, 123456000, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{nil, "NULL"},
+		{nilPtr, "NULL"},
+		{[]int(nil), "NULL"},
+		{true, "TRUE"},
+		{false, "FALSE"},
+		{42, "42"},
+		{int8(-8), "-8"},
+		{&n, "42"},
+		{uint64(math.MaxUint64), "18446744073709551615::UBIGINT"},
+		{1.5, "CAST(1.5 AS DOUBLE)"},
+		{-2.0, "CAST(-2 AS DOUBLE)"},
+		{1e21, "CAST(1e+21 AS DOUBLE)"},
+		{float32(0.1), "CAST(0.1 AS FLOAT)"},
+		{celsius(21.5), "CAST(21.5 AS DOUBLE)"},
+		{math.NaN(), "'nan'::DOUBLE"},
+		{math.Inf(-1), "'-inf'::DOUBLE"},
+		{"foo", "'foo'"},
+		{"", "''"},
+		{ts, "'2024-02-23 08:01:54.123456+00'::TIMESTAMPTZ"},
+		{time.Date(2024, 2, 23, 0, 0, 0, 0, time.UTC), "'2024-02-23 00:00:00+00'::TIMESTAMPTZ"},
+		{90 * time.Second, "to_microseconds(90000000)"},
+		{[]byte{0x00, 0x27, 0xff}, `'\x00\x27\xFF'::BLOB`},
+		{[]string{"a", "b'c"}, "['a', 'b''c']"},
+		{[2][]int{{1}, {}}, "[[1], []]"},
+		{[]any{1, nil, "x"}, "[1, NULL, 'x']"},
+		{map[string]any{"b": 2, "a": "x"}, "{'a': 'x', 'b': 2}"},
+	}
+	for _, test := range tests {
+		lit, err := Literal(test.value)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, lit, "%#v", test.value)
+	}
+}
+
+func TestLiteralAdversarial(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"'; DROP TABLE t; --", "'''; DROP TABLE t; --'"},
+		{"it's", "'it''s'"},
+		{"''", "''''''"},
+		{`back\slash\'`, `'back\slash\'''`},
+		{"$1 and $name", "'$1 and $name'"},
+		{"\"quoted\"", "'\"quoted\"'"},
+		{"/* comment */ --", "'/* comment */ --'"},
+		{"a\nb", "('a' || chr(10) || 'b')"},
+		{"\r\n.shell rm -rf /\n", "('' || chr(13) || '' || chr(10) || '.shell rm -rf /' || chr(10) || '')"},
+		{"x');\n.exit\n", "('x'');' || chr(10) || '.exit' || chr(10) || '')"},
+		{"héllo 🦆", "'héllo 🦆'"},
+	}
+	for _, test := range tests {
+		lit, err := Literal(test.value)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, lit)
+		// a literal is never split over lines, so dot commands can not be smuggled in
+		assert.NotContains(t, lit, "\n")
+	}
+
+	for _, value := range []any{"nul\x00byte", "\xff\xfe", []string{"ok", "\x00"}, map[int]string{1: "a"}, struct{}{}, make(chan int)} {
+		_, err := Literal(value)
+		assert.NotNil(t, err, "%#v", value)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	commands, err := prepare("SELECT * FROM t WHERE a = $1 AND b = $2;", []any{"x'y", 2})
+	assert.Nil(t, err)
+	assert.Len(t, commands, 3)
+	name := strings.TrimSuffix(strings.TrimPrefix(commands[2], "DEALLOCATE "), ";")
+	assert.Equal(t, "PREPARE "+name+" AS SELECT * FROM t WHERE a = $1 AND b = $2\n;", commands[0])
+	assert.Equal(t, "EXECUTE "+name+"('x''y', 2);", commands[1])
+
+	// the semicolon is not commented out
+	commands, err = prepare("SELECT $1 -- note", []any{1})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(commands[0], " AS SELECT $1 -- note\n;"))
+
+	commands, err = prepare("SELECT $name", []any{Named("name", "'; .exit")})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(commands[1], "(name := '''; .exit');"))
+
+	commands, err = prepare("SELECT 1", nil)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(commands[1], "("))
+
+	_, err = prepare("SELECT $1, $b", []any{1, Named("b", 2)})
+	assert.NotNil(t, err)
+	_, err = prepare("SELECT $b", []any{Named("b) ; DROP TABLE t; --", 2)})
+	assert.NotNil(t, err)
+	_, err = prepare("SELECT $1", []any{struct{}{}})
+	assert.NotNil(t, err)
+}
+
+func TestQueryArgs(t *testing.T) {
+	db := NewInMemoryDB()
+
+	res, err := db.QueryArgs("SELECT $1 AS s, $2 AS i, $3 AS l, $4 AS n", "it's; --\n", 5, []string{"a", "b"}, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, res, `[{"s":"it's; --\n","i":5,"l":["a","b"],"n":null}]`)
+
+	res, err = db.QueryArgs("SELECT $name AS name", Named("name", "'); DROP TABLE t; --"))
+	assert.Nil(t, err)
+	assert.Contains(t, res, `[{"name":"'); DROP TABLE t; --"}]`)
+}
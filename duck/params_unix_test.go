@@ -0,0 +1,25 @@
+//go:build unix
+
+package duck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryArgsCommands(t *testing.T) {
+	exe := fakeDuckDB(t, "echo '[{\"a\":1}]'\n")
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	res, err := db.QueryArgs("SELECT * FROM t WHERE name = $1", "a\n.shell touch pwned")
+	assert.Nil(t, err)
+	assert.Equal(t, "[{\"a\":1}]\n", res)
+
+	input := fakeInput(t, exe)
+	for _, line := range strings.Split(input, "\n") {
+		assert.False(t, strings.HasPrefix(line, ".shell"), line)
+	}
+	assert.Contains(t, input, "'a' || chr(10) || '.shell touch pwned'")
+}
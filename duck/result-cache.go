@@ -0,0 +1,163 @@
+package duck
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// resultCache keeps the frames returned by QueryFramesToFrames, so repeated
+// queries on the same frames don't run duckdb again
+type resultCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	maxRows int
+	rows    int
+	entries map[CacheKey]*resultEntry
+	lru     *list.List
+	stats   CacheStats
+	now     func() time.Time
+}
+
+type resultEntry struct {
+	key     CacheKey
+	frame   *sdk.Frame
+	rows    int
+	expires time.Time
+	element *list.Element
+}
+
+func newResultCache(ttl int, maxEntries int, maxRows int) *resultCache {
+	return &resultCache{
+		ttl:     time.Duration(ttl) * time.Second,
+		maxSize: maxEntries,
+		maxRows: maxRows,
+		entries: map[CacheKey]*resultEntry{},
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// get returns a copy of the cached frame
+func (c *resultCache) get(key CacheKey) (*sdk.Frame, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && c.expired(e) {
+		c.remove(e)
+		c.stats.Evictions++
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(e.element)
+	return copyFrame(e.frame), true
+}
+
+// set adds a copy of the frame, evicting expired and least recently used frames over the limits
+func (c *resultCache) set(key CacheKey, frame *sdk.Frame) {
+	rows := frame.Rows()
+	if c.maxRows > 0 && rows > c.maxRows {
+		return
+	}
+	e := &resultEntry{key: key, frame: copyFrame(frame), rows: rows}
+	if c.ttl > 0 {
+		e.expires = c.now().Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.remove(old)
+	}
+	for _, old := range c.entries {
+		if c.expired(old) {
+			c.remove(old)
+			c.stats.Evictions++
+		}
+	}
+	e.element = c.lru.PushFront(e)
+	c.entries[key] = e
+	c.rows += rows
+
+	for c.lru.Len() > 1 && ((c.maxSize > 0 && c.lru.Len() > c.maxSize) || (c.maxRows > 0 && c.rows > c.maxRows)) {
+		c.remove(c.lru.Back().Value.(*resultEntry))
+		c.stats.Evictions++
+	}
+}
+
+func (c *resultCache) expired(e *resultEntry) bool {
+	return !e.expires.IsZero() && !c.now().Before(e.expires)
+}
+
+func (c *resultCache) remove(e *resultEntry) {
+	delete(c.entries, e.key)
+	c.lru.Remove(e.element)
+	c.rows -= e.rows
+}
+
+func (c *resultCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if key.Name == name {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *resultCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		c.remove(e)
+	}
+}
+
+func (c *resultCache) statistics() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// copyFrame copies the frame, its meta and its fields, so a copy can be changed without
+// changing the cached frame or the frames of other callers
+func copyFrame(f *sdk.Frame) *sdk.Frame {
+	fields := make([]*sdk.Field, len(f.Fields))
+	for i, field := range f.Fields {
+		fields[i] = copyField(field)
+	}
+	c := sdk.NewFrame(f.Name, fields...)
+	c.RefID = f.RefID
+	if f.Meta != nil {
+		meta := *f.Meta
+		meta.Notices = append([]sdk.Notice{}, f.Meta.Notices...)
+		c.Meta = &meta
+	}
+	return c
+}
+
+// copyField copies the values, labels and config of the field
+func copyField(f *sdk.Field) *sdk.Field {
+	c := sdk.NewFieldFromFieldType(f.Type(), f.Len())
+	c.Name = f.Name
+	if f.Labels != nil {
+		c.Labels = f.Labels.Copy()
+	}
+	if f.Config != nil {
+		config := *f.Config
+		c.Config = &config
+	}
+	for i := 0; i < f.Len(); i++ {
+		c.Set(i, f.CopyAt(i))
+	}
+	return c
+}
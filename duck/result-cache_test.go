@@ -0,0 +1,124 @@
+package duck
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func resultFrame(rows int) *data.Frame {
+	return data.NewFrame("result", data.NewField("value", nil, make([]int64, rows)))
+}
+
+func TestResultCacheCopies(t *testing.T) {
+	c := newResultCache(10, 0, 0)
+	key := CacheKey{Name: "foo", Query: "select * from A", Fingerprint: "1"}
+
+	frame := resultFrame(2)
+	c.set(key, frame)
+	frame.AppendNotices(data.Notice{Text: "changed after set"})
+
+	cached, ok := c.get(key)
+	assert.True(t, ok)
+	assert.Equal(t, 2, cached.Rows())
+	assert.Nil(t, cached.Meta)
+
+	// notices added to a returned frame are not kept
+	cached.AppendNotices(data.Notice{Text: "changed after get"})
+	cached, ok = c.get(key)
+	assert.True(t, ok)
+	assert.Nil(t, cached.Meta)
+
+	// neither are changes to the fields of the frame that was set or returned
+	frame.Fields[0].Name = "changed"
+	frame.Fields[0].Set(0, int64(1))
+	cached.Fields[0].Labels = data.Labels{"host": "a"}
+	cached.Fields[0].SetConfig(&data.FieldConfig{Unit: "s"})
+	cached.Fields[0].Append(int64(2))
+	cached, ok = c.get(key)
+	assert.True(t, ok)
+	assert.Equal(t, resultFrame(2), cached)
+}
+
+func TestResultCacheTTL(t *testing.T) {
+	c := newResultCache(10, 0, 0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	key := CacheKey{Name: "foo"}
+
+	c.set(key, resultFrame(1))
+	now = now.Add(10 * time.Second)
+	_, ok := c.get(key)
+	assert.False(t, ok)
+	assert.Equal(t, CacheStats{Misses: 1, Evictions: 1}, c.statistics())
+}
+
+func TestResultCacheLimits(t *testing.T) {
+	c := newResultCache(10, 2, 10)
+
+	c.set(CacheKey{Name: "1"}, resultFrame(4))
+	c.set(CacheKey{Name: "2"}, resultFrame(4))
+	c.set(CacheKey{Name: "3"}, resultFrame(4))
+
+	// the least recently used frame is evicted for both the entry and row limits
+	_, ok := c.get(CacheKey{Name: "1"})
+	assert.False(t, ok)
+	_, ok = c.get(CacheKey{Name: "3"})
+	assert.True(t, ok)
+
+	// frames larger than the row limit are not cached
+	c.set(CacheKey{Name: "4"}, resultFrame(11))
+	_, ok = c.get(CacheKey{Name: "4"})
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.statistics().Entries)
+}
+
+func TestResultCacheInvalidate(t *testing.T) {
+	c := newResultCache(10, 0, 0)
+	c.set(CacheKey{Name: "foo", Query: "1"}, resultFrame(1))
+	c.set(CacheKey{Name: "foo", Query: "2"}, resultFrame(1))
+	c.set(CacheKey{Name: "bar", Query: "1"}, resultFrame(1))
+
+	c.invalidate("foo")
+	assert.Equal(t, 1, c.statistics().Entries)
+	c.purge()
+	assert.Equal(t, 0, c.statistics().Entries)
+	assert.Equal(t, 0, c.rows)
+}
+
+func TestQueryFramesToFramesResultCache(t *testing.T) {
+	// duckdb can't run, so a result can only come from the cache
+	db := NewInMemoryDB(Opts{Exe: filepath.Join(t.TempDir(), "missing"), ResultCacheDuration: 10})
+
+	frame := data.NewFrame("foo", data.NewField("value", nil, []string{"test"}))
+	frame.RefID = "foo"
+	frames := []*data.Frame{frame}
+
+	_, err := db.QueryFramesToFrames("foo", "select * from foo", frames)
+	assert.NotNil(t, err)
+
+	fingerprint, err := ContentFingerprint(frames)
+	assert.Nil(t, err)
+	db.results.set(CacheKey{Name: "foo", Query: "select * from foo", Fingerprint: fingerprint}, resultFrame(1))
+
+	model, err := db.QueryFramesToFrames("foo", "select * from foo", frames)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, model.Rows())
+	assert.Equal(t, "Results retrieved from cache", model.Meta.Notices[0].Text)
+	// the input frames only get a notice when their parquet files are cached
+	assert.Nil(t, frame.Meta)
+
+	// new data is not answered from the cache
+	changed := data.NewFrame("foo", data.NewField("value", nil, []string{"changed"}))
+	changed.RefID = "foo"
+	_, err = db.QueryFramesToFrames("foo", "select * from foo", []*data.Frame{changed})
+	assert.NotNil(t, err)
+
+	db.Invalidate("foo")
+	_, err = db.QueryFramesToFrames("foo", "select * from foo", frames)
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(1), db.ResultCacheStats().Hits)
+}
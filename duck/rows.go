@@ -0,0 +1,387 @@
+package duck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// row formats duckdb can print rows in as they are produced
+const (
+	RowFormatJSONLines = "jsonlines"
+	RowFormatCSV       = "csv"
+)
+
+// Rows is a cursor over the results of a query, which are read from the output of duckdb as it is
+// printed, rather than buffered. Use Next to read each row, and Close to stop reading early.
+// Rows read ahead at most Opts.RowBuffer rows: duckdb is paused while they are not read.
+type Rows struct {
+	format  string
+	columns []string
+	results chan rowResult
+	pending *rowResult
+	current []any
+	row     int
+	err     error
+	cancel  context.CancelFunc
+	closing atomic.Bool
+	done    bool
+}
+
+// rowResult is a row read by the reader, the columns of the results, or the error that stopped it
+type rowResult struct {
+	columns []string
+	values  []any
+	err     error
+}
+
+// QueryRows runs the query with the args, and returns a cursor over the rows
+func (d *DuckDB) QueryRows(query string, args ...any) (*Rows, error) {
+	return d.QueryRowsContext(context.Background(), query, args...)
+}
+
+// QueryRowsContext is QueryRows with cancellation. The duckdb process is killed when the context is
+// done or the rows are closed. Rows are always read from a new duckdb process, sessions are not used,
+// so file based databases with Opts.Sessions are rejected: the session holds the lock of the file.
+func (d *DuckDB) QueryRowsContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	if d.pool != nil && d.Name != "" {
+		return nil, validationError("rows can not be streamed from a file based database with sessions")
+	}
+	commands := []string{terminate(query)}
+	if len(args) > 0 {
+		var err error
+		if commands, err = prepare(query, args); err != nil {
+			return nil, err
+		}
+	}
+	commands = append([]string{".mode " + d.rowFormat}, commands...)
+
+	var cancel context.CancelFunc
+	if d.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(d.timeout)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	cmd, err := d.command(ctx)
+	if err != nil {
+		cancel()
+		return nil, newError(KindExecutable, "failed to start container: "+err.Error(), err)
+	}
+	script := d.script(commands)
+	cmd.Stdin = bytes.NewReader(script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, newError(KindExecutable, "failed to run duckdb: "+err.Error(), err)
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, commandError(err, "", nil)
+	}
+
+	r := &Rows{format: d.rowFormat, results: make(chan rowResult, d.rowBuffer), cancel: cancel}
+	go func() {
+		defer close(r.results)
+		readErr := r.read(ctx, bufio.NewReader(stdout))
+		// stop duckdb when the rows were not read to the end
+		if readErr != nil {
+			cancel()
+		}
+		err := cmd.Wait()
+		var runtimeErr *QueryError
+		if d.docker && err != nil {
+			runtimeErr = containerError(err, stderr.String())
+		}
+		// the error is read by Next, or discarded when the rows are closed
+		switch {
+		case r.closing.Load():
+		case readErr != nil:
+			r.results <- rowResult{err: conversionError(readErr)}
+		case ctx.Err() != nil:
+			logger.Error("command stopped", "cmd", string(script), "error", ctx.Err())
+			r.results <- rowResult{err: contextError(ctx.Err())}
+		case runtimeErr != nil:
+			logger.Error("error running container", "cmd", string(script), "error", stderr.String())
+			r.results <- rowResult{err: runtimeErr}
+		case stderr.Len() > 0:
+			logger.Error("error running command", "cmd", string(script), "error", stderr.String())
+			r.results <- rowResult{err: parseError(stderr.String(), commandLines(commands, 1))}
+		case err != nil:
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				r.results <- rowResult{err: commandError(err, "", nil)}
+			}
+		}
+	}()
+
+	// wait for the columns, so errors in the query are returned here
+	first, ok := <-r.results
+	for ok && first.err == nil && first.values == nil {
+		r.columns = first.columns
+		first, ok = <-r.results
+	}
+	if !ok {
+		r.finish()
+		return r, nil
+	}
+	if first.err != nil {
+		r.finish()
+		return nil, first.err
+	}
+	if first.columns != nil {
+		r.columns = first.columns
+	}
+	r.pending = &first
+	return r, nil
+}
+
+// read sends each row of the output, until the output ends or the context is done
+func (r *Rows) read(ctx context.Context, stdout *bufio.Reader) error {
+	if r.format == RowFormatCSV {
+		reader := csv.NewReader(stdout)
+		header, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !r.send(ctx, rowResult{columns: header}) {
+			return nil
+		}
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			values := make([]any, len(record))
+			for i, v := range record {
+				values[i] = v
+			}
+			if !r.send(ctx, rowResult{values: values}) {
+				return nil
+			}
+		}
+	}
+
+	var columns []string
+	for {
+		line, err := stdout.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			result := rowResult{}
+			if columns == nil {
+				keys, err := objectKeys(line)
+				if err != nil {
+					return fmt.Errorf("error decoding row: %w", err)
+				}
+				columns = keys
+				result.columns = columns
+			}
+			row := map[string]any{}
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			decoder.UseNumber()
+			if err := decoder.Decode(&row); err != nil {
+				return fmt.Errorf("error decoding row: %w", err)
+			}
+			result.values = make([]any, len(columns))
+			for i, col := range columns {
+				result.values[i] = row[col]
+			}
+			if !r.send(ctx, result) {
+				return nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// send waits until the result is read, and returns false when the context is done first
+func (r *Rows) send(ctx context.Context, result rowResult) bool {
+	select {
+	case r.results <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Columns returns the names of the columns. Queries without rows have no columns in jsonlines format.
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// Next reads the next row, and returns false when there are no more rows or reading failed
+func (r *Rows) Next() bool {
+	if r.done {
+		return false
+	}
+	r.row++
+	if r.pending != nil {
+		r.current = r.pending.values
+		r.pending = nil
+		return true
+	}
+	result, ok := <-r.results
+	if !ok || result.err != nil {
+		r.err = result.err
+		r.finish()
+		return false
+	}
+	r.current = result.values
+	return true
+}
+
+// Values returns the values of the row. Numbers of jsonlines rows are json.Number, values of csv rows are strings.
+func (r *Rows) Values() []any {
+	return r.current
+}
+
+// Map returns the values of the row by column name
+func (r *Rows) Map() map[string]any {
+	row := make(map[string]any, len(r.columns))
+	for i, col := range r.columns {
+		if i < len(r.current) {
+			row[col] = r.current[i]
+		}
+	}
+	return row
+}
+
+// Scan copies the values of the row into dest, with the conversions of QueryInto.
+// Empty values of csv rows are scanned as NULL into fields that are not strings.
+func (r *Rows) Scan(dest ...any) error {
+	if len(dest) != len(r.current) {
+		return fmt.Errorf("expected %d destinations, got %d", len(r.current), len(dest))
+	}
+	for i, d := range dest {
+		dst := reflect.ValueOf(d)
+		if dst.Kind() != reflect.Pointer || dst.IsNil() {
+			return fmt.Errorf("destination %d is not a pointer", i)
+		}
+		if err := scanValue(dst.Elem(), r.current[i], r.format); err != nil {
+			return &ScanError{Row: r.row - 1, Column: r.columns[i], Field: dst.Type().Elem().String(), Err: err}
+		}
+	}
+	return nil
+}
+
+func scanValue(dst reflect.Value, v any, format string) error {
+	s, ok := v.(string)
+	if format != RowFormatCSV || !ok {
+		return assign(dst, jsonValue(v))
+	}
+	if err := assign(dst, s); err == nil || s == "" && assign(dst, nil) == nil {
+		return nil
+	}
+	if n := json.Number(s); isNumber(n) {
+		if err := assign(dst, jsonValue(n)); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q can not be scanned into %s", s, dst.Type())
+}
+
+func isNumber(n json.Number) bool {
+	_, err := n.Float64()
+	return err == nil
+}
+
+// All returns an iterator over the rows and the error that stopped them, which can be used with range
+// on go 1.23. The rows are closed when the loop ends.
+func (r *Rows) All() func(yield func([]any, error) bool) {
+	return func(yield func([]any, error) bool) {
+		defer r.Close()
+		for r.Next() {
+			if !yield(r.Values(), nil) {
+				return
+			}
+		}
+		if r.err != nil {
+			yield(nil, r.err)
+		}
+	}
+}
+
+// Err returns the error that stopped reading the rows
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Buffered returns the number of rows read ahead of Next
+func (r *Rows) Buffered() int {
+	return len(r.results)
+}
+
+// Close stops reading the rows, and kills duckdb if it is still running
+func (r *Rows) Close() error {
+	if r.done {
+		return nil
+	}
+	r.closing.Store(true)
+	r.cancel()
+	r.finish()
+	return nil
+}
+
+// finish waits for the reader to stop
+func (r *Rows) finish() {
+	r.done = true
+	r.pending = nil
+	r.current = nil
+	for range r.results {
+	}
+	r.cancel()
+}
+
+// objectKeys returns the keys of a json object in order
+func objectKeys(object []byte) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(object))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fmt.Sprint(key))
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// rowFormat returns the format, or jsonlines when it is not supported
+func rowFormat(format string) string {
+	switch strings.ToLower(format) {
+	case RowFormatJSONLines, "":
+		return RowFormatJSONLines
+	case RowFormatCSV:
+		return RowFormatCSV
+	}
+	logger.Warn("unsupported row format, using jsonlines", "format", format)
+	return RowFormatJSONLines
+}
@@ -0,0 +1,230 @@
+//go:build unix
+
+package duck
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryRows(t *testing.T) {
+	exe := fakeDuckDB(t, `echo '{"z":1,"a":"x","n":null,"l":[1,2],"t":"2024-02-23 09:01:54"}'`+"\n"+
+		`echo '{"z":9007199254740993,"a":"y","n":1.5,"l":[],"t":"2024-02-23 09:01:55.5"}'`+"\n")
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	rows, err := db.QueryRows("SELECT * FROM t WHERE a = $1", "x'y")
+	assert.Nil(t, err)
+	defer rows.Close()
+	assert.Equal(t, []string{"z", "a", "n", "l", "t"}, rows.Columns())
+
+	assert.True(t, rows.Next())
+	var z int64
+	var a string
+	var n *float64
+	var l []int
+	var ts time.Time
+	assert.Nil(t, rows.Scan(&z, &a, &n, &l, &ts))
+	assert.Equal(t, int64(1), z)
+	assert.Nil(t, n)
+	assert.Equal(t, []int{1, 2}, l)
+	assert.Equal(t, time.Date(2024, 2, 23, 9, 1, 54, 0, time.UTC), ts)
+
+	assert.True(t, rows.Next())
+	assert.Nil(t, rows.Scan(&z, &a, &n, &l, &ts))
+	assert.Equal(t, int64(9007199254740993), z)
+	assert.Equal(t, 1.5, *n)
+	assert.Equal(t, "y", rows.Map()["a"])
+
+	assert.False(t, rows.Next())
+	assert.Nil(t, rows.Err())
+
+	input := fakeInput(t, exe)
+	assert.Contains(t, input, ".mode jsonlines")
+	assert.Contains(t, input, "('x''y');")
+}
+
+func TestQueryRowsCSV(t *testing.T) {
+	exe := fakeDuckDB(t, "printf 'i,s,f\\n1,\"a,\nb\",\\n2,,2.5\\n'\n")
+	db := NewInMemoryDB(Opts{Exe: exe, RowFormat: "csv"})
+
+	rows, err := db.QueryRows("SELECT * FROM t")
+	assert.Nil(t, err)
+	defer rows.Close()
+	assert.Equal(t, []string{"i", "s", "f"}, rows.Columns())
+
+	var i int
+	var s string
+	var f *float64
+	assert.True(t, rows.Next())
+	assert.Equal(t, []any{"1", "a,\nb", ""}, rows.Values())
+	assert.Nil(t, rows.Scan(&i, &s, &f))
+	assert.Equal(t, 1, i)
+	assert.Nil(t, f)
+
+	assert.True(t, rows.Next())
+	assert.Nil(t, rows.Scan(&i, &s, &f))
+	assert.Equal(t, "", s)
+	assert.Equal(t, 2.5, *f)
+	assert.False(t, rows.Next())
+
+	var b bool
+	err = rows.Scan(&i, &b, &f)
+	assert.NotNil(t, err)
+}
+
+func TestQueryRowsEmpty(t *testing.T) {
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, "")})
+
+	rows, err := db.QueryRows("SELECT * FROM t")
+	assert.Nil(t, err)
+	assert.Empty(t, rows.Columns())
+	assert.False(t, rows.Next())
+	assert.Nil(t, rows.Err())
+	assert.Nil(t, rows.Close())
+}
+
+func TestQueryRowsClose(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	exe := fakeDuckDB(t, "echo $$ > "+pidFile+"\nexec yes '{\"i\":1}'\n")
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	rows, err := db.QueryRows("SELECT * FROM range(1000000000000)")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		assert.True(t, rows.Next())
+	}
+	assert.Nil(t, rows.Close())
+	assert.Nil(t, rows.Err())
+	assert.False(t, rows.Next())
+
+	// the process is killed and reaped
+	b, err := os.ReadFile(pidFile)
+	assert.Nil(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	assert.Nil(t, err)
+	assert.NotNil(t, syscall.Kill(pid, 0))
+}
+
+func TestQueryRowsBackpressure(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	exe := fakeDuckDB(t, "i=0\nwhile true; do i=$((i+1)); echo \"{\\\"i\\\":$i}\"; echo $i > "+count+"; done\n")
+	db := NewInMemoryDB(Opts{Exe: exe, RowBuffer: 5})
+
+	rows, err := db.QueryRows("SELECT * FROM t")
+	assert.Nil(t, err)
+	defer rows.Close()
+
+	// duckdb is paused once the pipe and the read ahead rows are full
+	var last string
+	assert.Eventually(t, func() bool {
+		b, _ := os.ReadFile(count)
+		same := len(b) > 0 && string(b) == last
+		last = string(b)
+		return same && rows.Buffered() == 5
+	}, 10*time.Second, 200*time.Millisecond)
+
+	for i := 1; i <= 10; i++ {
+		assert.True(t, rows.Next())
+		assert.Equal(t, strconv.Itoa(i), rows.Values()[0].(interface{ String() string }).String())
+	}
+}
+
+func TestQueryRowsErrors(t *testing.T) {
+	exe := fakeDuckDB(t, printScript("", "Error: near line 2: Parser Error: syntax error at or near \"SELEC\"\n", 1))
+	db := NewInMemoryDB(Opts{Exe: exe})
+	_, err := db.QueryRows("SELEC 1")
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindQuery, qerr.Kind)
+	assert.Equal(t, 0, qerr.Statement)
+
+	// rows before the error are returned
+	exe = fakeDuckDB(t, "echo '{\"i\":1}'\necho 'Error: near line 2: Conversion Error: could not convert' >&2\nexit 1\n")
+	db = NewInMemoryDB(Opts{Exe: exe})
+	rows, err := db.QueryRows("SELECT i::INTEGER FROM t")
+	assert.Nil(t, err)
+	assert.True(t, rows.Next())
+	assert.False(t, rows.Next())
+	assert.True(t, errors.As(rows.Err(), &qerr))
+	assert.Equal(t, "Conversion Error", qerr.Class)
+
+	exe = fakeDuckDB(t, "echo 'not json'\n")
+	db = NewInMemoryDB(Opts{Exe: exe})
+	_, err = db.QueryRows("SELECT 1")
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindConversion, qerr.Kind)
+
+	db = NewInMemoryDB(Opts{Exe: filepath.Join(t.TempDir(), "missing")})
+	_, err = db.QueryRows("SELECT 1")
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindExecutable, qerr.Kind)
+
+	// a new process would compete with the session for the lock of the file
+	db = NewDuckDB(filepath.Join(t.TempDir(), "foo.db"), Opts{Exe: exe, Sessions: 1})
+	defer db.Close()
+	_, err = db.QueryRows("SELECT 1")
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindValidation, qerr.Kind)
+}
+
+func TestQueryRowsCancel(t *testing.T) {
+	exe := fakeDuckDB(t, "exec yes '{\"i\":1}'\n")
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rows, err := db.QueryRowsContext(ctx, "SELECT 1")
+	assert.Nil(t, err)
+	defer rows.Close()
+	assert.True(t, rows.Next())
+	cancel()
+	for rows.Next() {
+	}
+	var qerr *QueryError
+	assert.True(t, errors.As(rows.Err(), &qerr))
+	assert.Equal(t, KindCanceled, qerr.Kind)
+}
+
+func TestQueryRowsAll(t *testing.T) {
+	exe := fakeDuckDB(t, "exec yes '{\"i\":1}'\n")
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	rows, err := db.QueryRows("SELECT 1")
+	assert.Nil(t, err)
+	seen := 0
+	rows.All()(func(values []any, err error) bool {
+		assert.Nil(t, err)
+		seen++
+		return seen < 5
+	})
+	assert.Equal(t, 5, seen)
+	assert.False(t, rows.Next())
+}
+
+func TestQueryRowsDuckDB(t *testing.T) {
+	db := NewInMemoryDB()
+
+	rows, err := db.QueryRows("SELECT i, i * 2 AS j FROM range($1) t(i)", 1000000)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var i, j int64
+		assert.Nil(t, rows.Scan(&i, &j))
+		assert.Equal(t, i*2, j)
+		if count++; count == 1000 {
+			break
+		}
+	}
+	assert.Equal(t, 1000, count)
+}
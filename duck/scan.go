@@ -0,0 +1,265 @@
+package duck
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/scottlepp/go-duck/duck/data"
+)
+
+// ScanError is a column value that could not be scanned into its field
+type ScanError struct {
+	// Row is the index of the row, starting at 0
+	Row    int
+	Column string
+	// Type is the duckdb type of the column, when it is known
+	Type  string
+	Field string
+	Err   error
+}
+
+func (e *ScanError) Error() string {
+	column := e.Column
+	if e.Type != "" {
+		column += fmt.Sprintf(" (%s)", e.Type)
+	}
+	return fmt.Sprintf("failed to scan column %s of row %d into %s: %s", column, e.Row, e.Field, e.Err.Error())
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// QueryInto runs the query with the args, and scans each row into a T. Columns are mapped to the
+// exported fields of T by their `duck:"column"` tag, or by their name ignoring case. Fields tagged
+// `duck:"-"` are skipped. When T is not a struct, the results must have a single column.
+func QueryInto[T any](db *DuckDB, query string, args ...any) ([]T, error) {
+	return QueryIntoContext[T](context.Background(), db, query, args...)
+}
+
+// QueryIntoContext is QueryInto with cancellation.
+// Nullable columns need pointer fields, LIST and STRUCT columns are decoded from json into their fields.
+// Results with columns that have no field, or values that do not fit their field, return a conversion error.
+func QueryIntoContext[T any](ctx context.Context, db *DuckDB, query string, args ...any) ([]T, error) {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+		if n, ok := arg.(NamedArg); ok {
+			named[i] = driver.NamedValue{Ordinal: i + 1, Name: n.Name, Value: n.Value}
+		}
+	}
+	c := &conn{db: db}
+	results, err := c.QueryContext(ctx, query, named)
+	if err != nil {
+		return nil, err
+	}
+	r := results.(*rows)
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	fields, err := fieldPaths(t, r.columns)
+	if err != nil {
+		return nil, conversionError(err)
+	}
+	values := make([]T, len(r.values))
+	for i, row := range r.values {
+		item := reflect.ValueOf(&values[i]).Elem()
+		for j, value := range row {
+			dst := item
+			if fields != nil {
+				dst = item.FieldByIndex(fields[j])
+			}
+			if err := assign(dst, value); err != nil {
+				field := t.String()
+				if fields != nil {
+					field += "." + t.FieldByIndex(fields[j]).Name
+				}
+				return nil, conversionError(&ScanError{Row: i, Column: r.columns[j].Name, Type: r.columns[j].Type, Field: field, Err: err})
+			}
+		}
+	}
+	return values, nil
+}
+
+// fieldPaths returns the index of the struct field for each column, or nil when t is not a struct
+func fieldPaths(t reflect.Type, columns []data.Column) ([][]int, error) {
+	if t.Kind() != reflect.Struct || t == timeType || reflect.PointerTo(t).Implements(scannerType) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("results with %d columns can not be scanned into %s, which is not a struct", len(columns), t)
+		}
+		return nil, nil
+	}
+	byName := map[string][]int{}
+	structFields(t, nil, byName)
+	paths := make([][]int, len(columns))
+	unmapped := []string{}
+	for i, col := range columns {
+		path, ok := byName[col.Name]
+		if !ok {
+			path, ok = byName[strings.ToLower(col.Name)]
+		}
+		if !ok {
+			unmapped = append(unmapped, fmt.Sprintf("%q", col.Name))
+			continue
+		}
+		paths[i] = path
+	}
+	if len(unmapped) > 0 {
+		return nil, fmt.Errorf("%s has no field for columns %s", t, strings.Join(unmapped, ", "))
+	}
+	return paths, nil
+}
+
+// structFields adds the index of the fields of t by column name. Tagged names are also added as is,
+// other names only in lower case. Fields of embedded structs are added unless t has a field of the same name.
+func structFields(t reflect.Type, parent []int, byName map[string][]int) {
+	embedded := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("duck")
+		if tag == "-" || !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		path := append(append([]int{}, parent...), i)
+		if tag == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, field)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = field.Name
+		} else {
+			byName[name] = path
+		}
+		byName[strings.ToLower(name)] = path
+	}
+	for _, field := range embedded {
+		fields := map[string][]int{}
+		structFields(field.Type, append(append([]int{}, parent...), field.Index...), fields)
+		for name, path := range fields {
+			if _, ok := byName[name]; !ok {
+				byName[name] = path
+			}
+		}
+	}
+}
+
+// assign sets dst to the value of a column
+func assign(dst reflect.Value, v driver.Value) error {
+	if dst.CanAddr() && dst.Addr().Type().Implements(scannerType) {
+		return dst.Addr().Interface().(sql.Scanner).Scan(v)
+	}
+	if v == nil {
+		switch dst.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		return fmt.Errorf("NULL can not be scanned into %s, use a pointer", dst.Type())
+	}
+	if dst.Kind() == reflect.Pointer {
+		value := reflect.New(dst.Type().Elem())
+		if err := assign(value.Elem(), v); err != nil {
+			return err
+		}
+		dst.Set(value)
+		return nil
+	}
+
+	if dst.Kind() == reflect.Interface {
+		if b, ok := v.([]byte); ok {
+			// nested types are json
+			return json.Unmarshal(b, dst.Addr().Interface())
+		}
+		if reflect.TypeOf(v).AssignableTo(dst.Type()) {
+			dst.Set(reflect.ValueOf(v))
+			return nil
+		}
+	}
+
+	switch v := v.(type) {
+	case []byte:
+		// nested types are json
+		switch {
+		case dst.Type() == reflect.TypeOf(json.RawMessage{}) || dst.Type() == reflect.TypeOf([]byte{}):
+			dst.SetBytes(append([]byte{}, v...))
+			return nil
+		case dst.Kind() == reflect.String:
+			dst.SetString(string(v))
+			return nil
+		}
+		return json.Unmarshal(v, dst.Addr().Interface())
+	case time.Time:
+		if dst.Type() == timeType {
+			dst.Set(reflect.ValueOf(v))
+			return nil
+		}
+	case bool:
+		if dst.Kind() == reflect.Bool {
+			dst.SetBool(v)
+			return nil
+		}
+	case string:
+		if dst.Kind() == reflect.String {
+			dst.SetString(v)
+			return nil
+		}
+		if dst.Type() == timeType {
+			// timestamps of untyped results
+			t, err := data.ParseTime(v)
+			if err != nil {
+				return err
+			}
+			dst.Set(reflect.ValueOf(t))
+			return nil
+		}
+	case int64:
+		return assignNumber(dst, v, float64(v), func() (int64, bool) { return v, true }, func() (uint64, bool) { return uint64(v), v >= 0 })
+	case uint64:
+		return assignNumber(dst, v, float64(v), func() (int64, bool) { return int64(v), v <= math.MaxInt64 }, func() (uint64, bool) { return v, true })
+	case float64:
+		whole := v == math.Trunc(v) && !math.IsInf(v, 0)
+		return assignNumber(dst, v, v,
+			func() (int64, bool) { return int64(v), whole && v >= math.MinInt64 && v < math.MaxInt64 },
+			func() (uint64, bool) { return uint64(v), whole && v >= 0 && v < math.MaxUint64 })
+	}
+	return fmt.Errorf("%T can not be scanned into %s", v, dst.Type())
+}
+
+// assignNumber sets a numeric field, and fails when the value does not fit
+func assignNumber(dst reflect.Value, v any, f float64, toInt func() (int64, bool), toUint func() (uint64, bool)) error {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := toInt(); ok && !dst.OverflowInt(i) {
+			dst.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u, ok := toUint(); ok && !dst.OverflowUint(u) {
+			dst.SetUint(u)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if !dst.OverflowFloat(f) {
+			dst.SetFloat(f)
+			return nil
+		}
+	default:
+		return fmt.Errorf("%T can not be scanned into %s", v, dst.Type())
+	}
+	return fmt.Errorf("%v does not fit in %s", v, dst.Type())
+}
@@ -0,0 +1,172 @@
+//go:build unix
+
+package duck
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type scanBase struct {
+	ID int64 `duck:"id"`
+}
+
+type scanPoint struct {
+	X int    `json:"x"`
+	Y string `json:"y"`
+}
+
+type scanRow struct {
+	scanBase
+	Name    string
+	Score   *float64 `duck:"score"`
+	Created time.Time
+	Tags    []string       `duck:"tags"`
+	Point   *scanPoint     `duck:"point"`
+	Extra   any            `duck:"extra"`
+	Note    sql.NullString `duck:"note"`
+	Ignored string         `duck:"-"`
+}
+
+const scanColumns = `[{"column_name":"id","column_type":"BIGINT"},{"column_name":"NAME","column_type":"VARCHAR"},` +
+	`{"column_name":"score","column_type":"DOUBLE"},{"column_name":"created","column_type":"TIMESTAMP"},` +
+	`{"column_name":"tags","column_type":"VARCHAR[]"},{"column_name":"point","column_type":"STRUCT(x INTEGER, y VARCHAR)"},` +
+	`{"column_name":"extra","column_type":"MAP(VARCHAR, INTEGER)"},{"column_name":"note","column_type":"VARCHAR"}]`
+
+func TestQueryInto(t *testing.T) {
+	stdout := scanColumns + "\n" +
+		`[{"id":1,"NAME":"a","score":1.5,"created":"2024-02-23 09:01:54","tags":["x","y"],"point":{"x":1,"y":"p"},"extra":{"k":1},"note":"n"},` + "\n" +
+		`{"id":2,"NAME":"b","score":null,"created":"2024-02-23 09:01:55","tags":null,"point":null,"extra":null,"note":null}]` + "\n"
+	exe := fakeDuckDB(t, printScript(statementMarker+"\n"+stdout, "", 0))
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	rows, err := QueryInto[scanRow](db, "SELECT * FROM t WHERE id > $1", 0)
+	assert.Nil(t, err)
+	assert.Len(t, rows, 2)
+	assert.Contains(t, fakeInput(t, exe), "EXECUTE")
+
+	score := 1.5
+	assert.Equal(t, scanRow{
+		scanBase: scanBase{ID: 1},
+		Name:     "a",
+		Score:    &score,
+		Created:  time.Date(2024, 2, 23, 9, 1, 54, 0, time.UTC),
+		Tags:     []string{"x", "y"},
+		Point:    &scanPoint{X: 1, Y: "p"},
+		Extra:    map[string]any{"k": float64(1)},
+		Note:     sql.NullString{String: "n", Valid: true},
+	}, rows[0])
+	assert.Equal(t, scanRow{
+		scanBase: scanBase{ID: 2},
+		Name:     "b",
+		Created:  time.Date(2024, 2, 23, 9, 1, 55, 0, time.UTC),
+	}, rows[1])
+}
+
+func TestQueryIntoScalar(t *testing.T) {
+	exe := fakeDuckDB(t, printScript(statementMarker+"\n"+`[{"column_name":"n","column_type":"UBIGINT"}]`+"\n"+`[{"n":18446744073709551615},{"n":null}]`+"\n", "", 0))
+	db := NewInMemoryDB(Opts{Exe: exe})
+
+	values, err := QueryInto[*uint64](db, "SELECT n FROM t")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(18446744073709551615), *values[0])
+	assert.Nil(t, values[1])
+
+	_, err = QueryInto[int64](db, "SELECT n FROM t")
+	assert.NotNil(t, err)
+}
+
+func TestQueryIntoErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns string
+		rows    string
+		message string
+	}{
+		{
+			name:    "unmapped",
+			columns: `[{"column_name":"id","column_type":"BIGINT"},{"column_name":"missing","column_type":"VARCHAR"},{"column_name":"other","column_type":"VARCHAR"}]`,
+			rows:    `[{"id":1,"missing":"a","other":"b"}]`,
+			message: `duck.scanBase has no field for columns "missing", "other"`,
+		},
+		{
+			name:    "mistyped",
+			columns: `[{"column_name":"id","column_type":"VARCHAR"}]`,
+			rows:    `[{"id":"1"}]`,
+			message: "failed to scan column id (VARCHAR) of row 0 into duck.scanBase.ID: string can not be scanned into int64",
+		},
+		{
+			name:    "null",
+			columns: `[{"column_name":"id","column_type":"BIGINT"}]`,
+			rows:    `[{"id":1},{"id":null}]`,
+			message: "failed to scan column id (BIGINT) of row 1 into duck.scanBase.ID: NULL can not be scanned into int64, use a pointer",
+		},
+	}
+	for _, test := range tests {
+		exe := fakeDuckDB(t, printScript(statementMarker+"\n"+test.columns+"\n"+test.rows+"\n", "", 0))
+		db := NewInMemoryDB(Opts{Exe: exe})
+
+		_, err := QueryInto[scanBase](db, "SELECT * FROM t")
+		var qerr *QueryError
+		assert.True(t, errors.As(err, &qerr), test.name)
+		assert.Equal(t, KindConversion, qerr.Kind, test.name)
+		assert.Equal(t, test.message, err.Error(), test.name)
+	}
+
+	_, err := QueryInto[scanBase](NewInMemoryDB(), "SELECT $1", make(chan int))
+	assert.NotNil(t, err)
+}
+
+func TestQueryIntoOverflow(t *testing.T) {
+	type small struct {
+		I int8    `duck:"i"`
+		U uint8   `duck:"u"`
+		F float32 `duck:"f"`
+	}
+	tests := []struct {
+		rows  string
+		field string
+	}{
+		{`[{"i":300,"u":1,"f":1}]`, "I"},
+		{`[{"i":1,"u":-1,"f":1}]`, "U"},
+		{`[{"i":1,"u":1,"f":1e300}]`, "F"},
+		{`[{"i":1.5,"u":1,"f":1}]`, "I"},
+	}
+	for _, test := range tests {
+		columns := `[{"column_name":"i","column_type":"DOUBLE"},{"column_name":"u","column_type":"BIGINT"},{"column_name":"f","column_type":"DOUBLE"}]`
+		exe := fakeDuckDB(t, printScript(statementMarker+"\n"+columns+"\n"+test.rows+"\n", "", 0))
+		db := NewInMemoryDB(Opts{Exe: exe})
+
+		_, err := QueryInto[small](db, "SELECT * FROM t")
+		var scanErr *ScanError
+		assert.True(t, errors.As(err, &scanErr), test.rows)
+		assert.Equal(t, "duck.small."+test.field, scanErr.Field)
+	}
+}
+
+func TestQueryIntoDuckDB(t *testing.T) {
+	type person struct {
+		ID      int32             `duck:"id"`
+		Name    *string           `duck:"name"`
+		Born    time.Time         `duck:"born"`
+		Scores  []float64         `duck:"scores"`
+		Address map[string]string `duck:"address"`
+	}
+	db := NewInMemoryDB()
+
+	people, err := QueryInto[person](db, `SELECT 1::INTEGER AS id, $1 AS name, DATE '1990-01-02' AS born,
+		[1.5, 2] AS scores, {'city': 'Oslo'} AS address
+		UNION ALL SELECT 2, NULL, DATE '2000-01-01', [], NULL`, "O'Brien")
+	assert.Nil(t, err)
+	if !assert.Len(t, people, 2) {
+		return
+	}
+	assert.Equal(t, "O'Brien", *people[0].Name)
+	assert.Equal(t, []float64{1.5, 2}, people[0].Scores)
+	assert.Equal(t, "Oslo", people[0].Address["city"])
+	assert.Nil(t, people[1].Name)
+}
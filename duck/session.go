@@ -0,0 +1,357 @@
+package duck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errPoolClosed = errors.New("duckdb session pool is closed")
+var errSessionTimeout = errors.New("duckdb session did not respond in time")
+
+// sentinel sequence shared by all sessions so markers are never reused
+var sentinelSeq uint64
+
+// session is a long running duckdb process that reads commands from stdin.
+// Each batch of commands is followed by a sentinel that is printed to stdout
+// and raised as an error on stderr, so the output of a batch can be read back
+// without closing the process.
+type session struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *bufio.Reader
+	exited chan struct{}
+	broken bool
+	// lines is the number of lines written to stdin
+	lines int
+}
+
+func startSession(command func(ctx context.Context) (*exec.Cmd, error)) (*session, error) {
+	// the process outlives the calls that use it, so it gets its own context
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd, err := command(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	s := &session{
+		cmd:    cmd,
+		cancel: cancel,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		stderr: bufio.NewReader(stderr),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		err := cmd.Wait()
+		logger.Debug("duckdb session exited", "pid", cmd.Process.Pid, "error", err)
+		cancel()
+		close(s.exited)
+	}()
+	return s, nil
+}
+
+// alive returns false if the process exited or a previous batch left it in an unknown state
+func (s *session) alive() bool {
+	if s.broken {
+		return false
+	}
+	select {
+	case <-s.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+type output struct {
+	text string
+	err  error
+}
+
+// batch is the output of a script run on a session
+type batch struct {
+	stdout string
+	stderr string
+	// line is the line of the session input the script started on
+	line int
+}
+
+// run writes the script to the process and returns everything written to stdout and stderr for it.
+// The process is killed if the context is done before the output is read.
+func (s *session) run(ctx context.Context, script []byte, timeout time.Duration) (batch, error) {
+	sentinel := fmt.Sprintf("__go_duck_%d__", atomic.AddUint64(&sentinelSeq, 1))
+
+	var b bytes.Buffer
+	b.Write(script)
+	b.WriteString(fmt.Sprintf(".print %s %s", sentinel, newline))
+	b.WriteString(fmt.Sprintf("SELECT error('%s'); %s", sentinel, newline))
+
+	stdout := make(chan output, 1)
+	stderr := make(chan output, 1)
+	go func() {
+		text, err := readUntil(s.stdout, sentinel)
+		stdout <- output{text, err}
+	}()
+	go func() {
+		text, err := readUntil(s.stderr, sentinel)
+		stderr <- output{text, err}
+	}()
+
+	line := s.lines + 1
+	s.lines += bytes.Count(b.Bytes(), []byte(newline))
+	if _, err := s.stdin.Write(b.Bytes()); err != nil {
+		s.kill()
+		return batch{}, fmt.Errorf("failed to write to duckdb session: %w", err)
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	var out, errOut output
+	for received := 0; received < 2; received++ {
+		select {
+		case out = <-stdout:
+		case errOut = <-stderr:
+		case <-timer:
+			s.kill()
+			return batch{}, errSessionTimeout
+		case <-ctx.Done():
+			s.kill()
+			return batch{}, ctx.Err()
+		}
+	}
+	if out.err != nil || errOut.err != nil {
+		// the process died in the middle of the batch
+		s.kill()
+		return batch{}, fmt.Errorf("duckdb session exited: %w%s", errors.Join(out.err, errOut.err), errOut.text)
+	}
+	return batch{stdout: out.text, stderr: errOut.text, line: line}, nil
+}
+
+// dollarQuote matches the tag that starts a dollar quoted string, such as $$ or $body$
+var dollarQuote = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// unterminated returns true if the command ends in a string, quoted identifier or block comment.
+// A session would read the sentinel after it as part of the command, and wait for more input.
+func unterminated(command string) bool {
+	if strings.HasPrefix(strings.TrimSpace(command), ".") {
+		return false
+	}
+	open, _ := scanSQL(command)
+	return open
+}
+
+// scanSQL skips the strings, quoted identifiers and comments of the command. It returns true if
+// the command ends in one of them, other than a line comment, and the last character of the
+// command that is not in one of them or white space.
+func scanSQL(command string) (bool, byte) {
+	var last byte
+	for i := 0; i < len(command); i++ {
+		rest := command[i:]
+		var end string
+		switch {
+		case rest[0] == '\'' || rest[0] == '"':
+			end = rest[:1]
+		case strings.HasPrefix(rest, "--"):
+			end = newline
+		case strings.HasPrefix(rest, "/*"):
+			end = "*/"
+		case rest[0] == '$' && dollarQuote.MatchString(rest):
+			end = dollarQuote.FindString(rest)
+		default:
+			if !strings.ContainsRune(" \t\r\n", rune(rest[0])) {
+				last = rest[0]
+			}
+			continue
+		}
+		if end != newline && end != "*/" {
+			// the string or identifier is the last of the command so far
+			last = end[0]
+		}
+		start := len(end)
+		if end == "*/" || end == newline {
+			start = 2
+		}
+		n := strings.Index(rest[start:], end)
+		if n < 0 {
+			// a line comment ends with the command
+			return end != newline, last
+		}
+		i += start + n + len(end) - 1
+	}
+	return false, last
+}
+
+func readUntil(r *bufio.Reader, sentinel string) (string, error) {
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if strings.Contains(line, sentinel) {
+			return b.String(), nil
+		}
+		b.WriteString(line)
+		if err != nil {
+			return b.String(), err
+		}
+	}
+}
+
+func (s *session) kill() {
+	s.broken = true
+	s.cancel()
+}
+
+// close asks the process to exit by closing stdin, and kills it if it does not
+func (s *session) close() {
+	_ = s.stdin.Close()
+	select {
+	case <-s.exited:
+	case <-time.After(5 * time.Second):
+		s.kill()
+	}
+}
+
+// pool keeps a fixed number of duckdb sessions. Sessions are started lazily and
+// replaced when they crash or stop responding.
+type pool struct {
+	slots   chan *session
+	start   func() (*session, error)
+	timeout time.Duration
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	// held is the number of sessions taken by open transactions
+	held int
+}
+
+func newPool(size int, timeout time.Duration, start func() (*session, error)) *pool {
+	p := &pool{
+		slots:   make(chan *session, size),
+		start:   start,
+		timeout: timeout,
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		p.slots <- nil
+	}
+	return p
+}
+
+func (p *pool) acquire(ctx context.Context) (*session, error) {
+	p.mu.Lock()
+	held := p.held
+	p.mu.Unlock()
+	if held == cap(p.slots) {
+		// the call would wait for the transactions, which may be waiting for it
+		return nil, validationError("every duckdb session is held by an open transaction")
+	}
+	var s *session
+	select {
+	case <-p.done:
+		return nil, errPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case s = <-p.slots:
+	}
+	select {
+	case <-p.done:
+		p.slots <- s
+		return nil, errPoolClosed
+	default:
+	}
+	if s != nil && s.alive() {
+		return s, nil
+	}
+	if s != nil {
+		logger.Warn("replacing duckdb session", "pid", s.cmd.Process.Pid)
+		s.close()
+	}
+	s, err := p.start()
+	if err != nil {
+		p.slots <- nil
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *pool) release(s *session) {
+	p.slots <- s
+}
+
+// hold takes a session for a transaction until unhold is called
+func (p *pool) hold(ctx context.Context) (*session, error) {
+	s, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.held++
+	p.mu.Unlock()
+	return s, nil
+}
+
+// unhold returns the session of a transaction to the pool
+func (p *pool) unhold(s *session) {
+	p.mu.Lock()
+	p.held--
+	p.mu.Unlock()
+	p.release(s)
+}
+
+// run executes the script on the next free session
+func (p *pool) run(ctx context.Context, script []byte) (batch, error) {
+	s, err := p.acquire(ctx)
+	if err != nil {
+		return batch{}, err
+	}
+	defer p.release(s)
+	return s.run(ctx, script, p.timeout)
+}
+
+// close waits for running batches to finish and stops every session
+func (p *pool) close() {
+	p.once.Do(func() {
+		close(p.done)
+		for i := 0; i < cap(p.slots); i++ {
+			s := <-p.slots
+			if s != nil {
+				s.close()
+			}
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package duck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionCommands(t *testing.T) {
+	db := NewInMemoryDB(Opts{Sessions: 1})
+	defer db.Close()
+
+	commands := []string{
+		"CREATE TABLE t1 (i INTEGER, j INTEGER);",
+		"INSERT INTO t1 VALUES (1, 5);",
+	}
+	_, err := db.RunCommands(commands)
+	assert.Nil(t, err)
+
+	// the same process is reused, so the table is still there
+	res, err := db.Query("SELECT * from t1")
+	assert.Nil(t, err)
+	assert.Contains(t, res, `[{"i":1,"j":5}]`)
+}
+
+func TestSessionError(t *testing.T) {
+	db := NewInMemoryDB(Opts{Sessions: 1})
+	defer db.Close()
+
+	_, err := db.Query("SELECT * from missing;")
+	assert.NotNil(t, err)
+
+	// the session can still be used after an error
+	res, err := db.Query("SELECT 1 as i;")
+	assert.Nil(t, err)
+	assert.Contains(t, res, `[{"i":1}]`)
+}
+
+func TestSessionRecycle(t *testing.T) {
+	db := NewInMemoryDB(Opts{Sessions: 1})
+	defer db.Close()
+
+	_, err := db.Query("SELECT 1 as i;")
+	require.Nil(t, err)
+
+	// kill the running process
+	func() {
+		s := <-db.pool.slots
+		defer func() { db.pool.slots <- s }()
+		require.NotNil(t, s)
+		require.Nil(t, s.cmd.Process.Kill())
+		<-s.exited
+	}()
+
+	res, err := db.Query("SELECT 2 as i;")
+	assert.Nil(t, err)
+	assert.Contains(t, res, `[{"i":2}]`)
+}
+
+func TestSessionConcurrent(t *testing.T) {
+	db := NewInMemoryDB(Opts{Sessions: 3})
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := db.Query(fmt.Sprintf("SELECT %d as i;", i))
+			assert.Nil(t, err)
+			assert.Contains(t, res, fmt.Sprintf(`[{"i":%d}]`, i))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSessionClosed(t *testing.T) {
+	db := NewInMemoryDB(Opts{Sessions: 1})
+	err := db.Close()
+	assert.Nil(t, err)
+
+	_, err = db.Query("SELECT 1 as i;")
+	assert.ErrorIs(t, err, errPoolClosed)
+}
+
+func TestSessionQueryFrames(t *testing.T) {
+	db := NewInMemoryDB(Opts{Sessions: 1})
+	defer db.Close()
+
+	var values = []string{"test"}
+	frame := data.NewFrame("foo", data.NewField("value", nil, values))
+	frame.RefID = "foo"
+	frames := []*data.Frame{frame}
+
+	res, _, err := db.QueryFrames("foo", "select * from foo", frames)
+	assert.Nil(t, err)
+	assert.Contains(t, res, `[{"value":"test"}]`)
+
+	// the view is created again on the same session
+	model, err := db.QueryFramesToFrames("foo", "select * from foo", frames)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, model.Rows())
+}
+
+func TestSessionContextTimeout(t *testing.T) {
+	db := NewInMemoryDB(Opts{Sessions: 1})
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	_, err := db.QueryContext(ctx, "SELECT count(*) FROM range(100000000) a, range(100000) b;")
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	// the stuck process is replaced
+	res, err := db.Query("SELECT 1 as i;")
+	assert.Nil(t, err)
+	assert.Contains(t, res, `[{"i":1}]`)
+}
+
+func TestUnterminated(t *testing.T) {
+	tests := map[string]bool{
+		"SELECT 'abc'":                false,
+		"SELECT 'abc":                 true,
+		"SELECT 'it''s'":              false,
+		"SELECT 'it''s":               true,
+		`SELECT "a b" FROM t`:         false,
+		`SELECT "a b FROM t`:          true,
+		"SELECT 1 /* note":            true,
+		"SELECT 1 /* it's */":         false,
+		"SELECT 1 -- it's":            false,
+		"SELECT 1 -- note\n, 'a":      true,
+		"SELECT $$it's$$":             false,
+		"SELECT $body$ it's":          true,
+		"SELECT $1, $name":            false,
+		".print it's":                 false,
+		"SELECT '--', '/*', \"'\", 1": false,
+	}
+	for command, expected := range tests {
+		assert.Equal(t, expected, unterminated(command), command)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1;":            "SELECT 1;",
+		"SELECT 1":             "SELECT 1\n;",
+		"SELECT 1; -- note":    "SELECT 1; -- note",
+		"SELECT 1 -- note;":    "SELECT 1 -- note;\n;",
+		"SELECT 1 /* note; */": "SELECT 1 /* note; */\n;",
+		"SELECT ';'":           "SELECT ';'\n;",
+		".mode json":           ".mode json",
+		"":                     "",
+	}
+	for command, expected := range tests {
+		assert.Equal(t, expected, terminate(command), command)
+	}
+}
+
+func TestSessionUnterminated(t *testing.T) {
+	db := NewInMemoryDB(Opts{Sessions: 1, Exe: "missing-duckdb"})
+	defer db.Close()
+
+	// the command is rejected before a session is started, it would wait for input forever
+	_, err := db.Query("SELECT 'abc")
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindValidation, qerr.Kind)
+}
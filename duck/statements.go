@@ -0,0 +1,205 @@
+package duck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// statementMarker is printed after each statement, so the output of each statement can be told apart
+const statementMarker = "--go-duck-end-of-statement--"
+
+// changesLine is printed by duckdb after each statement while .changes is on
+var changesLine = regexp.MustCompile(`^changes: (\d+)\s+total_changes: \d+$`)
+
+// StatementResult is the result of one statement run by RunStatements
+type StatementResult struct {
+	Statement string
+	// Rows are the rows returned by the statement. Numbers are json.Number, so they keep their precision.
+	Rows []map[string]any
+	// Changes is the number of rows inserted, updated or deleted by the statement
+	Changes int64
+	// Err is the error of a failed statement. The statements after a failed statement are still run.
+	Err error
+}
+
+// RunStatements runs the statements, and returns a result for each of them
+func (d *DuckDB) RunStatements(statements []string) ([]StatementResult, error) {
+	return d.RunStatementsContext(context.Background(), statements)
+}
+
+// RunStatementsContext is RunStatements with cancellation. The error is set when duckdb could not run
+// the statements, errors of the statements themselves are in their results.
+func (d *DuckDB) RunStatementsContext(ctx context.Context, statements []string) ([]StatementResult, error) {
+	outputs, errs, err := d.runStatements(ctx, statements, false)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]StatementResult, len(statements))
+	for i, statement := range statements {
+		results[i] = StatementResult{Statement: statement, Err: errs[i]}
+		rows, changes := splitChanges(outputs[i])
+		if isChange(statement) {
+			results[i].Changes = changes
+		}
+		if results[i].Err != nil || strings.TrimSpace(rows) == "" {
+			continue
+		}
+		results[i].Rows, err = decodeRows(rows)
+		if err != nil {
+			results[i].Err = conversionError(fmt.Errorf("error decoding result rows: %w", err))
+		}
+	}
+	return results, nil
+}
+
+// QueryStatementsToFrames runs the statements, and returns a frame for the results of each query,
+// such as a SELECT. The frames are named name, with the statement as the executed query string.
+func (d *DuckDB) QueryStatementsToFrames(name string, statements []string) (sdk.Frames, error) {
+	return d.QueryStatementsToFramesContext(context.Background(), name, statements)
+}
+
+// QueryStatementsToFramesContext is QueryStatementsToFrames with cancellation.
+// The error of the first statement that failed is returned.
+func (d *DuckDB) QueryStatementsToFramesContext(ctx context.Context, name string, statements []string) (sdk.Frames, error) {
+	outputs, errs, err := d.runStatements(ctx, statements, true)
+	if err != nil {
+		return nil, err
+	}
+	frames := sdk.Frames{}
+	for i, statement := range statements {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if !isQuery(statement) {
+			continue
+		}
+		f := &sdk.Frame{}
+		rows, _ := splitChanges(outputs[i])
+		if err := resultsToFrame(name, rows, f, nil); err != nil {
+			return nil, conversionError(err)
+		}
+		if f.Meta == nil {
+			f.Meta = &sdk.FrameMeta{}
+		}
+		f.Meta.ExecutedQueryString = statement
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+// runStatements runs the statements with a marker printed after each of them, and returns
+// the output and error of each statement. When describe is set, queries are described first.
+func (d *DuckDB) runStatements(ctx context.Context, statements []string, describeQueries bool) ([]string, []error, error) {
+	// the output is split by statement, so it is always json
+	commands := []string{".mode json", ".changes on"}
+	// the statement of each command, or -1
+	owners := []int{-1, -1}
+	for i, statement := range statements {
+		if describeQueries && isQuery(statement) {
+			commands = append(commands, terminate(describe(statement)))
+			owners = append(owners, i)
+		}
+		commands = append(commands, terminate(statement), ".print "+statementMarker)
+		owners = append(owners, i, i)
+	}
+	// sessions are reused by other calls
+	commands = append(commands, ".changes off")
+	owners = append(owners, -1)
+
+	out, lines, err := d.runBatch(ctx, nil, commands)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errs := make([]error, len(statements))
+	for _, qerr := range parseErrors(out.stderr, lines) {
+		if qerr.Statement < 0 || owners[qerr.Statement] < 0 {
+			logger.Error("error running statements", "error", out.stderr)
+			return nil, nil, qerr
+		}
+		qerr.Statement = owners[qerr.Statement]
+		if errs[qerr.Statement] == nil {
+			errs[qerr.Statement] = qerr
+		}
+	}
+
+	outputs := make([]string, len(statements))
+	chunks := strings.Split(out.stdout, statementMarker+newline)
+	for i := range outputs {
+		if i < len(chunks) {
+			outputs[i] = chunks[i]
+		}
+	}
+	return outputs, errs, nil
+}
+
+// splitChanges removes the number of changed rows from the output of a statement
+func splitChanges(output string) (string, int64) {
+	var changes int64
+	var rows strings.Builder
+	for _, line := range strings.SplitAfter(output, newline) {
+		if m := changesLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			changes, _ = strconv.ParseInt(m[1], 10, 64)
+			continue
+		}
+		rows.WriteString(line)
+	}
+	return rows.String(), changes
+}
+
+// keyword returns the first keyword of the statement, in upper case
+func keyword(statement string) string {
+	s := strings.TrimLeft(statement, " \t\r\n(")
+	for strings.HasPrefix(s, "--") || strings.HasPrefix(s, "/*") {
+		end, skip := newline, 1
+		if strings.HasPrefix(s, "/*") {
+			end, skip = "*/", 2
+		}
+		i := strings.Index(s, end)
+		if i < 0 {
+			return ""
+		}
+		s = strings.TrimLeft(s[i+skip:], " \t\r\n(")
+	}
+	if i := strings.IndexAny(s, " \t\r\n(;"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.ToUpper(s)
+}
+
+// isQuery returns true for statements that return rows with columns DESCRIBE can report
+func isQuery(statement string) bool {
+	switch keyword(statement) {
+	case "SELECT", "WITH", "FROM", "VALUES", "TABLE", "PIVOT", "UNPIVOT":
+		return true
+	}
+	return false
+}
+
+// isChange returns true for statements that change rows
+func isChange(statement string) bool {
+	switch keyword(statement) {
+	case "INSERT", "UPDATE", "DELETE":
+		return true
+	}
+	return false
+}
+
+// decodeRows decodes the json rows of a statement
+func decodeRows(rows string) ([]map[string]any, error) {
+	decoder := json.NewDecoder(strings.NewReader(rows))
+	decoder.UseNumber()
+	var results []map[string]any
+	if err := decoder.Decode(&results); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return results, nil
+}
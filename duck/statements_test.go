@@ -0,0 +1,45 @@
+package duck
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunStatementsCommandError(t *testing.T) {
+	db := NewInMemoryDB(Opts{Exe: filepath.Join(t.TempDir(), "missing")})
+	_, err := db.RunStatements([]string{"SELECT 1"})
+
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindExecutable, qerr.Kind)
+}
+
+func TestKeyword(t *testing.T) {
+	tests := map[string]string{
+		"select 1":                     "SELECT",
+		"  (SELECT 1)":                 "SELECT",
+		"-- comment\ninsert into t":    "INSERT",
+		"/* a */ /* b */ with x as ()": "WITH",
+		"DELETE;":                      "DELETE",
+		"-- only a comment":            "",
+	}
+	for statement, expected := range tests {
+		assert.Equal(t, expected, keyword(statement), statement)
+	}
+	assert.True(t, isQuery("FROM t"))
+	assert.False(t, isQuery("CREATE TABLE t (i INTEGER)"))
+	assert.True(t, isChange("update t set i = 1"))
+}
+
+func TestParseErrors(t *testing.T) {
+	stderr := "Error: near line 2: Parser Error: syntax error at or near \"FRM\"\n" +
+		"Error: near line 4: Catalog Error: Table with name x does not exist!\nDid you mean \"t\"?\n"
+	errs := parseErrors(stderr, []int{2, 3, 4})
+	assert.Len(t, errs, 2)
+	assert.Equal(t, 0, errs[0].Statement)
+	assert.Equal(t, 2, errs[1].Statement)
+	assert.Equal(t, "Table with name x does not exist!\nDid you mean \"t\"?", errs[1].Message)
+}
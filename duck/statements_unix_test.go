@@ -0,0 +1,69 @@
+//go:build unix
+
+package duck
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunStatements(t *testing.T) {
+	// the script starts with .mode, .mode json and .changes on, and statements are terminated on a
+	// line of their own, so the statements start on lines 4, 7, 10 and 13
+	stdout := "changes: 0   total_changes: 0\n" + statementMarker + "\n" +
+		"changes: 2   total_changes: 2\n" + statementMarker + "\n" +
+		"[{\"i\":1},\n{\"i\":2}]\nchanges: 2   total_changes: 2\n" + statementMarker + "\n" +
+		statementMarker + "\n"
+	stderr := "Error: near line 13: Catalog Error: Table with name missing does not exist!\n"
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, printScript(stdout, stderr, 1))})
+
+	statements := []string{
+		"CREATE TABLE t (i INTEGER)",
+		"INSERT INTO t VALUES (1), (2)",
+		"SELECT * FROM t",
+		"SELECT * FROM missing",
+	}
+	results, err := db.RunStatements(statements)
+	assert.Nil(t, err)
+	assert.Len(t, results, 4)
+
+	assert.Nil(t, results[0].Err)
+	assert.Empty(t, results[0].Rows)
+	assert.Equal(t, int64(2), results[1].Changes)
+	assert.Equal(t, []map[string]any{{"i": json.Number("1")}, {"i": json.Number("2")}}, results[2].Rows)
+	assert.Equal(t, int64(0), results[2].Changes)
+
+	var qerr *QueryError
+	assert.True(t, errors.As(results[3].Err, &qerr))
+	assert.Equal(t, 3, qerr.Statement)
+	assert.Equal(t, "Catalog Error", qerr.Class)
+}
+
+func TestQueryStatementsToFrames(t *testing.T) {
+	stdout := statementMarker + "\n" +
+		`[{"column_name":"i","column_type":"INTEGER"}]` + "\n" + `[{"i":1}]` + "\n" + statementMarker + "\n"
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, printScript(stdout, "", 0))})
+
+	frames, err := db.QueryStatementsToFrames("foo", []string{"CREATE TABLE t AS SELECT 1 AS i", "SELECT * FROM t"})
+	assert.Nil(t, err)
+	assert.Len(t, frames, 1)
+	assert.Equal(t, "foo", frames[0].Name)
+	assert.Equal(t, "SELECT * FROM t", frames[0].Meta.ExecutedQueryString)
+	v, ok := frames[0].Fields[0].ConcreteAt(0)
+	assert.True(t, ok)
+	assert.Equal(t, int32(1), v)
+}
+
+func TestQueryStatementsToFramesError(t *testing.T) {
+	// the query is described on line 7, before it is run
+	stderr := "Error: near line 7: Binder Error: Referenced column \"x\" not found\n"
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, printScript(statementMarker+"\n"+statementMarker+"\n", stderr, 1))})
+
+	_, err := db.QueryStatementsToFrames("foo", []string{"CREATE TABLE t AS SELECT 1 AS i", "SELECT x FROM t"})
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, 1, qerr.Statement)
+}
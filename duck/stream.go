@@ -0,0 +1,114 @@
+package duck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/scottlepp/go-duck/duck/data"
+)
+
+// errNotRead is returned by a pipe writer when duckdb finished without reading the pipe
+var errNotRead = errors.New("duckdb did not read the frames")
+
+// pipePollInterval is how often a writer checks whether duckdb has opened its pipe
+const pipePollInterval = 5 * time.Millisecond
+
+// stream runs the commands with the frames loaded into temp tables from named pipes, so the
+// frame data is never written to disk. Each RefID has a pipe that is written while duckdb reads it.
+// The pipes are removed when the commands are done, and writers stop if duckdb exits without reading.
+func (f *FrameData) stream(ctx context.Context, frames []*sdk.Frame, commands ...string) (string, error) {
+	dir, err := os.MkdirTemp("", data.TempDirPrefix)
+	if err != nil {
+		logger.Error("failed to create temp dir", "error", err)
+		return "", conversionError(err)
+	}
+	defer wipe(Dirs{"stream": dir})
+
+	refs, byRef := framesByRef(frames)
+	cmds := []string{}
+	pipes := make([]string, len(refs))
+	for i, ref := range refs {
+		pipes[i] = filepath.Join(dir, fmt.Sprintf("%d.ndjson", i))
+		if err := mkfifo(pipes[i]); err != nil {
+			logger.Error("failed to create pipe", "pipe", pipes[i], "error", err)
+			return "", conversionError(err)
+		}
+		cmds = append(cmds, fmt.Sprintf("CREATE OR REPLACE TEMP TABLE %s AS (SELECT * from %s);", ref, pipeReader(pipes[i])))
+	}
+	first := len(cmds)
+	cmds = append(cmds, commands...)
+	if f.db.pool != nil {
+		// sessions are reused, so don't keep the frame data in memory after the query
+		for _, ref := range refs {
+			cmds = append(cmds, fmt.Sprintf("DROP TABLE IF EXISTS %s;", ref))
+		}
+	}
+
+	writeCtx, stop := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	errs := make([]error, len(refs))
+	for i, ref := range refs {
+		wg.Add(1)
+		go func(i int, frames []*sdk.Frame) {
+			defer wg.Done()
+			errs[i] = writePipe(writeCtx, pipes[i], frames, f.db.parquet)
+		}(i, byRef[ref])
+	}
+
+	res, err := f.db.RunCommandsContext(ctx, cmds)
+	// duckdb is done with the pipes, writers that are still waiting or writing give up
+	stop()
+	wg.Wait()
+	if err != nil {
+		return "", queryStatement(err, first, len(commands))
+	}
+	for i, werr := range errs {
+		if werr != nil {
+			// duckdb may have read part of the frames, so the results can't be trusted
+			logger.Error("failed to stream frames", "refId", refs[i], "error", werr)
+			return "", conversionError(werr)
+		}
+	}
+	return res, nil
+}
+
+// framesByRef groups the frames by RefID, returning the RefIDs in the order they first appear
+func framesByRef(frames []*sdk.Frame) ([]string, map[string][]*sdk.Frame) {
+	refs := []string{}
+	byRef := map[string][]*sdk.Frame{}
+	for _, frame := range frames {
+		if _, ok := byRef[frame.RefID]; !ok {
+			refs = append(refs, frame.RefID)
+		}
+		byRef[frame.RefID] = append(byRef[frame.RefID], frame)
+	}
+	return refs, byRef
+}
+
+// pipeReader returns the duckdb table function that reads a pipe written by writePipe
+func pipeReader(pipe string) string {
+	return fmt.Sprintf("read_json('%s', format = 'newline_delimited')", strings.ReplaceAll(pipe, "'", "''"))
+}
+
+// writePipe writes the frames to the pipe once duckdb opens it.
+// It returns errNotRead if ctx is done first, and stops writing when ctx is done.
+func writePipe(ctx context.Context, pipe string, frames []*sdk.Frame, opts data.ParquetOpts) error {
+	w, err := openPipe(ctx, pipe)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	unblock := context.AfterFunc(ctx, func() {
+		// duckdb stopped reading, fail the write that is waiting for it
+		_ = w.SetWriteDeadline(time.Now())
+	})
+	defer unblock()
+	return data.WriteNDJSONWithOpts(w, frames, opts)
+}
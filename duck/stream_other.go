@@ -0,0 +1,19 @@
+//go:build !unix
+
+package duck
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+var errStreamUnsupported = errors.New("streaming frames is only supported on unix")
+
+func mkfifo(path string) error {
+	return errStreamUnsupported
+}
+
+func openPipe(ctx context.Context, pipe string) (*os.File, error) {
+	return nil, errStreamUnsupported
+}
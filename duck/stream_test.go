@@ -0,0 +1,87 @@
+//go:build unix
+
+package duck
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	sdk "github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// streamScript validates every query, and prints what it reads from the first pipe of the
+// commands with read, such as "cat" or "head -c 10"
+func streamScript(read string) string {
+	return astScript +
+		`pipe=$(printf '%s' "$input" | sed -n "s/.*read_json('\([^']*\)'.*/\1/p" | head -n 1)` + "\n" +
+		read + " \"$pipe\"\n"
+}
+
+func TestStreamFrames(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, streamScript("cat")), Stream: true, CacheDuration: 10})
+	defer db.Close()
+
+	frame := sdk.NewFrame("foo", sdk.NewField("value", sdk.Labels{"host": "a"}, []string{"test"}))
+	frame.RefID = "foo"
+	res, cached, err := db.QueryFrames("foo", "select * from foo", []*sdk.Frame{frame})
+	assert.Nil(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, `{"value":"test","host":"a"}`+"\n", res)
+
+	// nothing is kept on disk
+	assertEmptyDir(t, dir)
+	assert.Equal(t, 0, db.CacheStats().Entries)
+}
+
+func TestStreamNotRead(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, streamScript("true")), Stream: true})
+
+	_, _, err := db.QueryFrames("foo", "select * from foo", testFrames())
+
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindConversion, qerr.Kind)
+	assert.ErrorIs(t, err, errNotRead)
+	assertEmptyDir(t, dir)
+}
+
+func TestStreamProcessExits(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	// duckdb exits after reading part of the frames
+	db := NewInMemoryDB(Opts{Exe: fakeDuckDB(t, streamScript("head -c 10")), Stream: true})
+
+	values := make([]string, 100000)
+	for i := range values {
+		values[i] = "a value that fills the pipe buffer"
+	}
+	frame := sdk.NewFrame("foo", sdk.NewField("value", nil, values))
+	frame.RefID = "foo"
+	_, _, err := db.QueryFramesContext(context.Background(), "foo", "select * from foo", []*sdk.Frame{frame})
+
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindConversion, qerr.Kind)
+	assertEmptyDir(t, dir)
+}
+
+func TestStreamCommandError(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	db := NewInMemoryDB(Opts{Exe: filepath.Join(dir, "missing"), Stream: true})
+	fd := &FrameData{db: db}
+
+	_, err := fd.stream(context.Background(), testFrames(), "select * from foo")
+
+	var qerr *QueryError
+	assert.True(t, errors.As(err, &qerr))
+	assert.Equal(t, KindExecutable, qerr.Kind)
+	assertEmptyDir(t, dir)
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package duck
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
+
+// openPipe opens the pipe for writing once it has a reader. The pipe is opened without blocking,
+// so waiting for the reader can stop when ctx is done.
+func openPipe(ctx context.Context, pipe string) (*os.File, error) {
+	for {
+		w, err := os.OpenFile(pipe, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err == nil {
+			return w, nil
+		}
+		if !errors.Is(err, syscall.ENXIO) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errNotRead
+		case <-time.After(pipePollInterval):
+		}
+	}
+}